@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	cc "github.com/ivanpirog/coloredcobra"
 	"github.com/mikelorant/muting2/internal/app"
@@ -12,12 +13,32 @@ import (
 
 func NewRootCmd() *cobra.Command {
 	var (
-		bind      string
-		debug     bool
-		host      string
-		name      string
-		namespace string
-		service   string
+		banner       bool
+		bind         string
+		opsBind      string
+		profiling    bool
+		drain        time.Duration
+		timeout      time.Duration
+		debug        bool
+		collision    string
+		matching     string
+		encoding     string
+		sources      []string
+		selector     string
+		file         string
+		url          string
+		interval     time.Duration
+		shadow       bool
+		annotations  []string
+		classes      []string
+		certificates bool
+		capture      app.CaptureOptions
+		host         string
+		name         string
+		namespace    string
+		service      string
+		logLevel     string
+		logFormat    string
 	)
 
 	cmd := &cobra.Command{
@@ -25,12 +46,32 @@ func NewRootCmd() *cobra.Command {
 		Short: "A brief description of your application",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			opts := app.Options{
-				Bind:      bind,
-				Debug:     debug,
-				Host:      host,
-				Name:      name,
-				Namespace: namespace,
-				Service:   service,
+				Bind:         bind,
+				OpsBind:      opsBind,
+				Profiling:    profiling,
+				Drain:        drain,
+				Timeout:      timeout,
+				Debug:        debug,
+				Host:         host,
+				Name:         name,
+				Namespace:    namespace,
+				Service:      service,
+				Collision:    collision,
+				Matching:     matching,
+				Encoding:     encoding,
+				Sources:      sources,
+				Selector:     selector,
+				RulesFile:    file,
+				RulesURL:     url,
+				Interval:     interval,
+				Shadow:       shadow,
+				Annotations:  annotations,
+				Classes:      classes,
+				Certificates: certificates,
+				Capture:      capture,
+				LogLevel:     logLevel,
+				LogFormat:    logFormat,
+				Banner:       banner,
 			}
 
 			if err := app.New(opts); err != nil {
@@ -42,11 +83,37 @@ func NewRootCmd() *cobra.Command {
 	}
 
 	cmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug")
+	cmd.Flags().BoolVarP(&banner, "banner", "", true, "Print banner on startup")
 	cmd.Flags().StringVarP(&bind, "bind", "", ":8443", "Address to bind")
+	cmd.Flags().StringVarP(&opsBind, "ops-bind", "", ":8080", "Address to bind for metrics, health and profiling")
+	cmd.Flags().BoolVarP(&profiling, "pprof", "", false, "Serve pprof profiles on the ops listener")
+	cmd.Flags().DurationVarP(&drain, "drain-delay", "", 5*time.Second, "Time to fail readiness before shutting down")
+	cmd.Flags().DurationVarP(&timeout, "shutdown-timeout", "", 30*time.Second, "Maximum time to wait for shutdown")
 	cmd.Flags().StringVarP(&host, "host", "", "", "Host endpoint name")
 	cmd.Flags().StringVarP(&name, "name", "", "muting", "Resource name")
 	cmd.Flags().StringVarP(&namespace, "namespace", "", "default", "Resource namespace")
 	cmd.Flags().StringVarP(&service, "service", "", "muting", "Resource service")
+	cmd.Flags().StringVarP(&collision, "collision-policy", "", "warn", "Host collision policy (ignore, warn, deny)")
+	cmd.Flags().StringVarP(&matching, "matching", "", "label", "Default suffix matching mode (label, legacy)")
+	cmd.Flags().StringSliceVarP(&sources, "rule-sources", "", []string{"configmap"}, "Rule sources in order of precedence (configmap, namespaces, file, url)")
+	cmd.Flags().StringVarP(&selector, "rules-selector", "", "muting/transforms=true", "Label selector of namespace rule config maps")
+	cmd.Flags().StringVarP(&file, "rules-file", "", "", "Path of the rules file")
+	cmd.Flags().StringVarP(&url, "rules-url", "", "", "URL of the rules")
+	cmd.Flags().DurationVarP(&interval, "rules-url-interval", "", time.Minute, "Interval between background fetches of the rules URL")
+	cmd.Flags().StringVarP(&encoding, "host-encoding", "", "punycode", "Encoding of rewritten internationalized hosts (punycode, unicode)")
+	cmd.Flags().BoolVarP(&shadow, "shadow", "", false, "Report host rewrites without applying them")
+	cmd.Flags().StringSliceVarP(&annotations, "host-annotations", "", nil, "Annotations with comma separated hosts to transform (e.g. external-dns.alpha.kubernetes.io/hostname)")
+	cmd.Flags().StringSliceVarP(&classes, "ingress-classes", "", nil, "Ingress classes to transform, including the default class when unset (default all)")
+	cmd.Flags().BoolVarP(&certificates, "certificates", "", false, "Also transform the hosts of cert-manager Certificates")
+	cmd.Flags().StringVarP(&capture.Path, "capture", "", "", "Path of the file to capture admission reviews to as JSON lines, or - for stdout with logs on stderr")
+	cmd.Flags().Float64VarP(&capture.Sample, "capture-sample", "", 1, "Fraction of admission reviews to capture")
+	cmd.Flags().StringSliceVarP(&capture.Namespaces, "capture-namespaces", "", nil, "Namespace patterns of admission reviews to capture (default all)")
+	cmd.Flags().Int64VarP(&capture.MaxSize, "capture-max-size", "", 100<<20, "Size in bytes at which the capture file is rotated")
+	cmd.Flags().IntVarP(&capture.MaxFiles, "capture-max-files", "", 5, "Number of rotated capture files to keep")
+	cmd.Flags().StringVarP(&logLevel, "log-level", "", "", "Log level (error, info, debug, trace)")
+	cmd.Flags().StringVarP(&logFormat, "log-format", "", "console", "Log format (console, json)")
+
+	cmd.AddCommand(NewRulesCmd())
 
 	cc.Init(&cc.Config{
 		RootCmd:         cmd,
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mikelorant/muting2/internal/app"
+	"github.com/mikelorant/muting2/internal/format"
+	"github.com/spf13/cobra"
+)
+
+func NewRulesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Inspect transform rules",
+	}
+
+	cmd.AddCommand(NewRulesListCmd())
+	cmd.AddCommand(NewRulesValidateCmd())
+
+	return cmd
+}
+
+func NewRulesListCmd() *cobra.Command {
+	var (
+		endpoint  string
+		name      string
+		namespace string
+		output    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the effective transform rules",
+		Example: `  # Query the ops endpoint of a running instance
+  muting2 rules list --endpoint http://localhost:8080
+
+  # Read the config map directly
+  muting2 rules list --namespace default --name muting -o json`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rs, err := app.ListRules(cmd.Context(), app.RulesOptions{
+				Endpoint:  endpoint,
+				Namespace: namespace,
+				Name:      name,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to list rules: %w", err)
+			}
+
+			return format.Write(os.Stdout, output, rs)
+		},
+	}
+
+	cmd.Flags().StringVarP(&endpoint, "endpoint", "", "", "Ops endpoint of a running instance, reads the config map when empty")
+	cmd.Flags().StringVarP(&name, "name", "", "muting", "Resource name")
+	cmd.Flags().StringVarP(&namespace, "namespace", "", "default", "Resource namespace")
+	cmd.Flags().StringVarP(&output, "output", "o", format.Table, "Output format (table, json)")
+
+	return cmd
+}
+
+func NewRulesValidateCmd() *cobra.Command {
+	var matching string
+
+	cmd := &cobra.Command{
+		Use:   "validate FILE",
+		Short: "Validate a transform rules file",
+		Example: `  # Validate rules before applying them to the config map
+  muting2 rules validate transforms.yaml`,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("unable to read rules: %w", err)
+			}
+
+			warnings, err := app.ValidateRules(string(data), matching)
+			if err != nil {
+				return fmt.Errorf("invalid rules: %v: %w", args[0], err)
+			}
+
+			for _, w := range warnings {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", w)
+			}
+
+			fmt.Printf("%v: valid\n", args[0])
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&matching, "matching", "", "label", "Default suffix matching mode (label, legacy)")
+
+	return cmd
+}
@@ -8,31 +8,40 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	admissionregistrationv1typed "k8s.io/client-go/kubernetes/typed/admissionregistration/v1"
 )
 
 type AdmissionConfig struct {
-	Client  admissionregistrationv1typed.MutatingWebhookConfigurationsGetter
-	Config  *admissionregistrationv1.MutatingWebhookConfiguration
-	Options AdmissionConfigOptions
+	Client     AdmissionConfigClient
+	Config     *admissionregistrationv1.MutatingWebhookConfiguration
+	Validating *admissionregistrationv1.ValidatingWebhookConfiguration
+	Options    AdmissionConfigOptions
+}
+
+type AdmissionConfigClient interface {
+	admissionregistrationv1typed.MutatingWebhookConfigurationsGetter
+	admissionregistrationv1typed.ValidatingWebhookConfigurationsGetter
 }
 
 type AdmissionConfigOptions struct {
-	Namespace string
-	Name      string
-	Service   string
-	URL       string
-	CABundle  []byte
-	Client    admissionregistrationv1typed.MutatingWebhookConfigurationsGetter
+	Namespace    string
+	Name         string
+	Service      string
+	URL          string
+	CABundle     []byte
+	Certificates bool
+	Client       AdmissionConfigClient
 }
 
 func newAdmissionConfig(o AdmissionConfigOptions) AdmissionConfig {
 	return AdmissionConfig{
-		Client:  o.Client,
-		Config:  admissionConfig(o),
-		Options: o,
+		Client:     o.Client,
+		Config:     admissionConfig(o),
+		Validating: validatingConfig(o),
+		Options:    o,
 	}
 }
 
@@ -40,19 +49,31 @@ func (w *AdmissionConfig) apply(ctx context.Context) error {
 	ctx, span := otel.Tracer(name).Start(ctx, "Apply")
 	defer span.End()
 
+	if err := w.applyMutating(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if err := w.applyValidating(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (w *AdmissionConfig) applyMutating(ctx context.Context) error {
 	cl := w.Client.MutatingWebhookConfigurations()
 
 	obj, err := cl.Get(ctx, w.Options.Name, metav1.GetOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
 		return fmt.Errorf("unable to get admission config: %w", err)
 	}
 
 	if apierrors.IsNotFound(err) {
 		if _, err := cl.Create(ctx, w.Config, metav1.CreateOptions{}); err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
 			return fmt.Errorf("unable to create admission config: %w", err)
 		}
 		return nil
@@ -60,14 +81,35 @@ func (w *AdmissionConfig) apply(ctx context.Context) error {
 
 	w.Config.ObjectMeta.ResourceVersion = obj.ObjectMeta.ResourceVersion
 	if _, err := cl.Update(ctx, w.Config, metav1.UpdateOptions{}); err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
 		return fmt.Errorf("unable to update admission config: %w", err)
 	}
 
 	return nil
 }
 
+func (w *AdmissionConfig) applyValidating(ctx context.Context) error {
+	cl := w.Client.ValidatingWebhookConfigurations()
+
+	obj, err := cl.Get(ctx, w.Options.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to get validating admission config: %w", err)
+	}
+
+	if apierrors.IsNotFound(err) {
+		if _, err := cl.Create(ctx, w.Validating, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("unable to create validating admission config: %w", err)
+		}
+		return nil
+	}
+
+	w.Validating.ObjectMeta.ResourceVersion = obj.ObjectMeta.ResourceVersion
+	if _, err := cl.Update(ctx, w.Validating, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("unable to update validating admission config: %w", err)
+	}
+
+	return nil
+}
+
 func admissionConfig(o AdmissionConfigOptions) *admissionregistrationv1.MutatingWebhookConfiguration {
 	name := fmt.Sprintf("%v.%v.svc.cluster.local", o.Service, o.Namespace)
 	fail := admissionregistrationv1.Fail
@@ -106,12 +148,23 @@ func admissionConfig(o AdmissionConfigOptions) *admissionregistrationv1.Mutating
 		Rule:       rule,
 	}}
 
-	matchLabels := map[string]string{
-		(o.Service): "enabled",
+	if o.Certificates {
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{certificateGVK.Group},
+				APIVersions: []string{certificateGVK.Version},
+				Resources:   []string{"certificates"},
+			},
+		})
 	}
 
 	namespaceSelector := &metav1.LabelSelector{
-		MatchLabels: matchLabels,
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      o.Service,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   []string{NamespaceEnabled, NamespaceShadow},
+		}},
 	}
 
 	webhooks := []admissionregistrationv1.MutatingWebhook{{
@@ -130,10 +183,69 @@ func admissionConfig(o AdmissionConfigOptions) *admissionregistrationv1.Mutating
 	}
 }
 
+// validatingConfig registers the validating webhook for the transforms config
+// map. Failures are ignored so that the config map can still be fixed while
+// muting is unavailable.
+func validatingConfig(o AdmissionConfigOptions) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	name := fmt.Sprintf("rules.%v.%v.svc.cluster.local", o.Service, o.Namespace)
+	ignore := admissionregistrationv1.Ignore
+	sideEffect := admissionregistrationv1.SideEffectClassNone
+	path := validatePath
+
+	clientConfig := admissionregistrationv1.WebhookClientConfig{
+		CABundle: o.CABundle,
+	}
+
+	if o.URL != "" {
+		url := o.URL + validatePath
+		clientConfig.URL = &url
+	} else {
+		clientConfig.Service = &admissionregistrationv1.ServiceReference{
+			Name:      o.Name,
+			Namespace: o.Namespace,
+			Path:      &path,
+		}
+	}
+
+	rules := []admissionregistrationv1.RuleWithOperations{{
+		Operations: []admissionregistrationv1.OperationType{
+			admissionregistrationv1.Create,
+			admissionregistrationv1.Update,
+		},
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"configmaps"},
+		},
+	}}
+
+	namespaceSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			corev1.LabelMetadataName: o.Namespace,
+		},
+	}
+
+	webhooks := []admissionregistrationv1.ValidatingWebhook{{
+		Name:                    name,
+		AdmissionReviewVersions: []string{"v1"},
+		SideEffects:             &sideEffect,
+		ClientConfig:            clientConfig,
+		Rules:                   rules,
+		NamespaceSelector:       namespaceSelector,
+		FailurePolicy:           &ignore,
+	}}
+
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: o.Name},
+		Webhooks:   webhooks,
+	}
+}
+
 func (o AdmissionConfigOptions) String() string {
 	return format.SliceToFormattedLines([]string{
 		fmt.Sprintf("Namespace: %v", o.Namespace),
 		fmt.Sprintf("Name: %v", o.Name),
 		fmt.Sprintf("Service: %v", o.Service),
+		fmt.Sprintf("Certificates: %v", o.Certificates),
 	})
 }
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAdmissionConfigApply(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	opts := AdmissionConfigOptions{
+		Namespace: testNamespace,
+		Name:      testName,
+		Service:   "muting",
+		CABundle:  []byte("ca"),
+		Client:    cs.AdmissionregistrationV1(),
+	}
+
+	ac := newAdmissionConfig(opts)
+	if err := ac.apply(ctx); err != nil {
+		t.Fatalf("apply() create error = %v", err)
+	}
+
+	got, err := cs.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get admission config: %v", err)
+	}
+
+	if diff := cmp.Diff(admissionConfig(opts).Webhooks, got.Webhooks); diff != "" {
+		t.Errorf("created webhooks mismatch (-want +got):\n%v", diff)
+	}
+
+	vgot, err := cs.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get validating admission config: %v", err)
+	}
+
+	if diff := cmp.Diff(validatingConfig(opts).Webhooks, vgot.Webhooks); diff != "" {
+		t.Errorf("created validating webhooks mismatch (-want +got):\n%v", diff)
+	}
+
+	opts.CABundle = []byte("rotated")
+
+	ac = newAdmissionConfig(opts)
+	if err := ac.apply(ctx); err != nil {
+		t.Fatalf("apply() update error = %v", err)
+	}
+
+	got, err = cs.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get admission config: %v", err)
+	}
+
+	if diff := cmp.Diff([]byte("rotated"), got.Webhooks[0].ClientConfig.CABundle); diff != "" {
+		t.Errorf("updated CA bundle mismatch (-want +got):\n%v", diff)
+	}
+
+	vgot, err = cs.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, testName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get validating admission config: %v", err)
+	}
+
+	if diff := cmp.Diff([]byte("rotated"), vgot.Webhooks[0].ClientConfig.CABundle); diff != "" {
+		t.Errorf("updated validating CA bundle mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestAdmissionConfigCertificates(t *testing.T) {
+	tests := map[string]struct {
+		certificates bool
+		want         []string
+	}{
+		"disabled": {want: []string{"ingresses"}},
+		"enabled":  {certificates: true, want: []string{"ingresses", "certificates"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := admissionConfig(AdmissionConfigOptions{
+				Namespace:    testNamespace,
+				Name:         testName,
+				Service:      "muting",
+				Certificates: tc.certificates,
+			})
+
+			var got []string
+			for _, r := range cfg.Webhooks[0].Rules {
+				got = append(got, r.Resources...)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("resources mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -2,20 +2,26 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/common-nighthawk/go-figure"
-	"github.com/hackebrot/turtle"
-	"github.com/mikelorant/muting2/internal/format"
+	"github.com/go-logr/logr"
+	"github.com/mikelorant/muting2/internal/logging"
 	"github.com/mikelorant/muting2/internal/tls"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -24,17 +30,45 @@ type App struct {
 	Transforms    *Transforms
 	TLS           *tls.TLS
 	Observability Observability
-	Log           *log.Logger
+	Log           logr.Logger
 	Client        *kubernetes.Clientset
+	Health        *Health
+	Applied       *HealthGate
+	TLSReady      *HealthGate
+	Collisions    *Collisions
+	Ops           *OpsServer
+
+	expiring sync.Once
 }
 
 type Options struct {
-	Bind      string
-	Debug     bool
-	Host      string
-	Name      string
-	Namespace string
-	Service   string
+	Bind         string
+	OpsBind      string
+	Profiling    bool
+	Drain        time.Duration
+	Timeout      time.Duration
+	Debug        bool
+	Host         string
+	Name         string
+	Namespace    string
+	Service      string
+	Collision    string
+	Matching     string
+	Encoding     string
+	Sources      []string
+	Selector     string
+	RulesFile    string
+	RulesURL     string
+	Interval     time.Duration
+	Shadow       bool
+	Annotations  []string
+	Classes      []string
+	Certificates bool
+	Capture      CaptureOptions
+	LogLevel     string
+	LogFormat    string
+	Banner       bool
+	Config       *rest.Config
 }
 
 const (
@@ -42,37 +76,85 @@ const (
 	tracerServiceName = "muting"
 	profilerName      = "muting.app"
 	profilerAddr      = "http://localhost:4040"
+	eventsTimeout     = time.Minute
+	healthTimeout     = 5 * time.Second
+	informerResync    = 10 * time.Minute
+	tlsExpiryWindow   = 7 * 24 * time.Hour
+)
+
+var (
+	ErrAdmissionConfigNotApplied = errors.New("admission config not applied")
+	ErrCertificateNotReady       = errors.New("certificate not ready")
 )
 
+var eventsBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    6,
+}
+
 func New(o Options) error {
-	a := App{
-		Options: o,
-		Log:     log.New(os.Stdout, "", 0),
+	return Run(context.Background(), o)
+}
+
+// Run starts the application and blocks until the context is cancelled or a
+// termination signal is received.
+func Run(ctx context.Context, o Options) error {
+	l, err := newLogger(o)
+	if err != nil {
+		return fmt.Errorf("unable to create logger: %w", err)
 	}
 
-	figure.NewFigure("Muting", "", true).Print()
+	a := App{
+		Options:  o,
+		Log:      l,
+		Health:   newHealth(HealthOptions{Timeout: healthTimeout}),
+		Applied:  newHealthGate(ErrAdmissionConfigNotApplied),
+		TLSReady: newHealthGate(ErrCertificateNotReady),
+	}
 
-	ctx := context.Background()
+	if o.Banner && o.Capture.Path != CaptureStdout {
+		figure.NewFigure("Muting", "", true).Print()
+	}
 
 	if err := a.configureObservability(ctx); err != nil {
 		return fmt.Errorf("unable to configure observability: %w", err)
 	}
-	defer a.Observability.TracerProvider.Shutdown(ctx)
-
-	if err := a.getTLS(ctx); err != nil {
-		return fmt.Errorf("unable to do TLS: %w", err)
-	}
+	defer a.shutdownObservability()
 
-	cl, err := newClient(ctx)
+	cl, err := newClient(ctx, a.Options.Config)
 	if err != nil {
 		return fmt.Errorf("unable to get new client: %w", err)
 	}
 	a.Client = cl
 
+	if err := a.newTransformer(); err != nil {
+		return fmt.Errorf("unable to create transformer: %w", err)
+	}
+
+	// The ops server starts before the other steps so that readiness
+	// reports each of them failing until it completes.
+	if err := a.startOpsServer(); err != nil {
+		return fmt.Errorf("unable to do ops server: %w", err)
+	}
+	defer a.shutdownOpsServer()
+
+	if err := a.getTLS(ctx); err != nil {
+		return fmt.Errorf("unable to do TLS: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	if err := a.getTransformer(ctx); err != nil {
 		return fmt.Errorf("unable to do transformer: %w", err)
 	}
 
+	if err := a.startCollisions(ctx); err != nil {
+		return fmt.Errorf("unable to do collisions: %w", err)
+	}
+
 	if err := a.applyAdmissionConfig(ctx); err != nil {
 		return fmt.Errorf("unable to do webhook: %w", err)
 	}
@@ -102,34 +184,106 @@ func (a *App) configureObservability(ctx context.Context) error {
 	return nil
 }
 
-func (a *App) getTransformer(ctx context.Context) error {
-	ctx, span := otel.Tracer(name).Start(ctx, "GetTransformer")
-	defer span.End()
+func (a *App) shutdownObservability() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.Options.Timeout)
+	defer cancel()
 
+	if err := a.Observability.Shutdown(ctx); err != nil {
+		a.Log.Error(err, "Unable to shutdown observability.")
+	}
+}
+
+func (a *App) newTransformer() error {
 	t, err := newTransformer(TransformOptions{
 		Namespace: a.Options.Namespace,
 		Name:      a.Options.Name,
+		Sources:   a.Options.Sources,
+		Selector:  a.Options.Selector,
+		File:      a.Options.RulesFile,
+		URL:       a.Options.RulesURL,
+		Interval:  a.Options.Interval,
+		Matching:  a.Options.Matching,
+		Encoding:  a.Options.Encoding,
 		Client:    a.Client.CoreV1(),
+		Metrics:   a.Observability.Registry,
+		Log:       a.Log.WithName("transforms"),
 	})
 	if err != nil {
-		return fmt.Errorf("unable to create transformer: %w", err)
+		return err
 	}
 	a.Transforms = t
 
+	a.Health.AddReadinessCheck("tls", a.checkTLS)
+	a.Health.AddReadinessCheck("transforms", t.Loaded.Check)
+	a.Health.AddReadinessCheck("admissionconfig", a.Applied.Check)
+
+	return nil
+}
+
+// getTransformer starts the rule sources and loads the rules. Rules that fail
+// to load are logged and retried on admission while readiness fails.
+func (a *App) getTransformer(ctx context.Context) error {
+	ctx, span := otel.Tracer(name).Start(ctx, "GetTransformer")
+	defer span.End()
+
+	t := a.Transforms
+
+	if err := t.Start(ctx); err != nil {
+		return fmt.Errorf("unable to start transformer: %w", err)
+	}
+
 	ts, err := t.read(ctx)
 	if err != nil {
-		return fmt.Errorf("unable to read transforms: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		a.Log.Error(err, "Unable to load transforms, readiness fails until they load.")
+		return nil
+	}
+
+	for _, st := range t.SourceStatus() {
+		if st.Error != "" {
+			continue
+		}
+		a.Log.Info("Loaded rule source.", "source", st.Source, "version", st.Version, "count", st.Rules)
 	}
 
-	fmt.Println(turtle.Emojis["scissors"], " Transforms:")
-	if len(ts) != 0 {
-		fmt.Println(format.SliceToFormattedLines(ts))
+	a.Log.Info("Loaded transforms.", "count", len(ts), "transforms", ts)
+
+	return nil
+}
+
+func (a *App) startOpsServer() error {
+	ops, err := newOpsServer(OpsServerOptions{
+		Addr:      a.Options.OpsBind,
+		Profiling: a.Options.Profiling,
+		Sources:   a.Transforms.SourcesHandler(),
+		Rules:     a.Transforms.RulesHandler(),
+		Metrics:   a.Observability.Registry,
+		Health:    a.Health,
+		Log:       a.Log.WithName("ops"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to start ops server: %w", err)
 	}
-	fmt.Println()
+	a.Ops = ops
+
+	a.Log.Info("Started ops server.",
+		"opsBind", a.Options.OpsBind,
+		"profiling", a.Options.Profiling,
+	)
 
 	return nil
 }
 
+func (a *App) shutdownOpsServer() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.Options.Timeout)
+	defer cancel()
+
+	if err := a.Ops.Shutdown(ctx); err != nil {
+		a.Log.Error(err, "Unable to shutdown ops server.")
+	}
+}
+
 func (a *App) getTLS(ctx context.Context) error {
 	cn, dn := a.buildTLSOptions()
 	t, err := tls.NewTLS(ctx, tls.Options{
@@ -141,10 +295,49 @@ func (a *App) getTLS(ctx context.Context) error {
 	}
 
 	a.TLS = t
+	a.TLSReady.Set(nil)
+
+	expiry := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tls_certificate_expiry_timestamp_seconds",
+		Help:      "Time at which the serving certificate expires.",
+	}, func() float64 {
+		return float64(t.Keypair.Certificate.NotAfter.Unix())
+	})
+	if err := a.Observability.Registry.Register(expiry); err != nil {
+		return fmt.Errorf("unable to register metric: %w", err)
+	}
+
+	a.Log.Info("Generated TLS keypair.",
+		"commonName", t.Options.CommonName,
+		"dnsNames", t.Options.DNSNames,
+	)
+
+	return nil
+}
 
-	fmt.Println(turtle.Emojis["lock"], "TLS Options:")
-	fmt.Println(t.Options)
-	fmt.Println()
+func (a *App) startCollisions(ctx context.Context) error {
+	c, err := newCollisions(CollisionsOptions{
+		Policy: a.Options.Collision,
+		Resync: informerResync,
+		Client: a.Client,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create collisions: %w", err)
+	}
+	a.Collisions = c
+
+	if c.Options.Policy == CollisionPolicyIgnore {
+		return nil
+	}
+
+	a.Health.AddReadinessCheck("ingresses", c.Synced.Check)
+
+	if err := c.Start(ctx); err != nil {
+		return fmt.Errorf("unable to start ingress informer: %w", err)
+	}
+
+	a.Log.Info("Started ingress informer.", "policy", c.Options.Policy)
 
 	return nil
 }
@@ -154,41 +347,101 @@ func (a *App) applyAdmissionConfig(ctx context.Context) error {
 	defer span.End()
 
 	ac := newAdmissionConfig(AdmissionConfigOptions{
-		Client:    a.Client.AdmissionregistrationV1(),
-		Name:      a.Options.Name,
-		Namespace: a.Options.Namespace,
-		Service:   a.Options.Service,
-		URL:       a.buildAdmissionConfigURL(),
-		CABundle:  a.TLS.CA.GetCertificate(),
+		Client:       a.Client.AdmissionregistrationV1(),
+		Name:         a.Options.Name,
+		Namespace:    a.Options.Namespace,
+		Service:      a.Options.Service,
+		URL:          a.buildAdmissionConfigURL(),
+		CABundle:     a.TLS.CA.GetCertificate(),
+		Certificates: a.Options.Certificates,
 	})
 
-	fmt.Println(turtle.Emojis["vertical_traffic_light"], "Admission Config Options:")
-	fmt.Println(ac.Options)
-	fmt.Println()
-
 	if err := ac.apply(ctx); err != nil {
 		return fmt.Errorf("unable to apply webhook: %w", err)
 	}
+	a.Applied.Set(nil)
 
-	a.Log.Println(turtle.Emojis["art"], "Applied admission config.")
+	a.Log.Info("Applied admission config.",
+		"namespace", ac.Options.Namespace,
+		"name", ac.Options.Name,
+		"service", ac.Options.Service,
+	)
 
 	return nil
 }
 
 func (a *App) startServer(ctx context.Context) error {
-	wh, err := newWebhook(ctx, a.Transforms, a.Observability.Registry)
+	ev := newEvents(EventsOptions{
+		Component: a.Options.Service,
+		Backoff:   eventsBackoff,
+		Timeout:   eventsTimeout,
+		Client:    a.Client,
+		Log:       a.Log.WithName("events"),
+	})
+	defer ev.Shutdown()
+
+	icl, err := newIngressClassLister(ctx, a.Client.NetworkingV1())
+	if err != nil {
+		return fmt.Errorf("unable to start ingress class informer: %w", err)
+	}
+
+	wh, err := newWebhook(ctx, WebhookOptions{
+		Namespace:   a.Options.Namespace,
+		Name:        a.Options.Name,
+		Matching:    a.Options.Matching,
+		Transformer: a.Transforms,
+		Annotations: a.Options.Annotations,
+		Classes: ClassOptions{
+			Classes: a.Options.Classes,
+			Lister:  icl,
+		},
+		Shadow: ShadowOptions{
+			Enabled: a.Options.Shadow,
+			Label:   a.Options.Service,
+			Lister:  a.Transforms.namespaces,
+			Client:  a.Client.CoreV1(),
+		},
+		Certificates: a.Options.Certificates,
+		Events:       ev,
+		Collisions:   a.Collisions,
+		Metrics:      a.Observability.Registry,
+		Log:          a.Log.WithName("webhook"),
+	})
 	if err != nil {
 		return fmt.Errorf("unable to get handler: %w", err)
 	}
 
+	var capture *Capture
+	if a.Options.Capture.Path != "" {
+		o := a.Options.Capture
+		o.Log = a.Log.WithName("capture")
+
+		capture, err = newCapture(o)
+		if err != nil {
+			return fmt.Errorf("unable to start capture: %w", err)
+		}
+		defer capture.Close()
+
+		a.Log.Info("Capturing admission reviews.",
+			"path", o.Path,
+			"sample", o.Sample,
+			"namespaces", o.Namespaces,
+		)
+	}
+
 	opts := ServerOptions{
 		Addr:    a.Options.Bind,
+		Drain:   a.Options.Drain,
+		Timeout: a.Options.Timeout,
 		Webhook: wh,
-		Metrics: a.Observability.Registry,
+		Capture: capture,
 		Keypair: a.TLS.Keypair,
+		CA:      a.TLS.CA.GetCertificate(),
+		Health:  a.Health,
+		Log:     a.Log.WithName("server"),
 	}
 
-	a.Log.Printf("%v Starting server [%v].\n", turtle.Emojis["white_check_mark"], a.Options.Bind)
+	a.Log.Info("Starting server.", "bind", a.Options.Bind)
 
 	if err := newServer(ctx, opts); err != nil {
 		return fmt.Errorf("unable to start server: %w", err)
@@ -197,15 +450,58 @@ func (a *App) startServer(ctx context.Context) error {
 	return nil
 }
 
-func newClient(ctx context.Context) (*kubernetes.Clientset, error) {
+// checkTLS reports whether the serving certificate is ready. An expiring
+// certificate is logged and exposed as a metric rather than failing
+// readiness, which would take every replica out of service at once.
+func (a *App) checkTLS(ctx context.Context) error {
+	if err := a.TLSReady.Check(ctx); err != nil {
+		return err
+	}
+
+	expiry := a.TLS.Keypair.Certificate.NotAfter
+	if time.Until(expiry) < tlsExpiryWindow {
+		a.expiring.Do(func() {
+			a.Log.Info("Certificate is expiring, restart to regenerate it.", "expiry", expiry)
+		})
+	}
+
+	return nil
+}
+
+func newLogger(o Options) (logr.Logger, error) {
+	level := o.LogLevel
+	if level == "" {
+		level = logging.LevelInfo
+		if o.Debug {
+			level = logging.LevelDebug
+		}
+	}
+
+	// Captures written to stdout would be mixed with the logs.
+	var w io.Writer = os.Stdout
+	if o.Capture.Path == CaptureStdout {
+		w = os.Stderr
+	}
+
+	return logging.New(logging.Options{
+		Level:  level,
+		Format: o.LogFormat,
+		Writer: w,
+	})
+}
+
+func newClient(ctx context.Context, cfg *rest.Config) (*kubernetes.Clientset, error) {
 	_, span := otel.Tracer(name).Start(ctx, "newClient")
 	defer span.End()
 
-	cfg, err := ctrl.GetConfig()
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		return nil, fmt.Errorf("unable to get config: %w", err)
+	if cfg == nil {
+		c, err := ctrl.GetConfig()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, fmt.Errorf("unable to get config: %w", err)
+		}
+		cfg = c
 	}
 
 	cl, err := kubernetes.NewForConfig(cfg)
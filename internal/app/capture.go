@@ -0,0 +1,326 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// CaptureStdout writes captured admission reviews to stdout. Logs are written
+// to stderr instead so that the two are not mixed.
+const CaptureStdout = "-"
+
+const redacted = "REDACTED"
+
+var ErrCaptureSampleInvalid = errors.New("capture sample must be between 0 and 1")
+
+// lastAppliedAnnotation holds the object as last applied by kubectl including
+// its annotations.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// secretAnnotation matches the keys of annotations whose values are redacted
+// from captured objects. Words are only matched as whole segments of the key
+// so that keys such as auth-url are kept.
+var secretAnnotation = regexp.MustCompile(`(?i)(^|[/._-])(secrets?|tokens?|passw(or)?ds?|credentials?|api[-_]?keys?|private[-_]?keys?)($|[/._-])|(^|/)auth$`)
+
+// Capture writes admission reviews and the responses of the webhook as JSON
+// lines. Files are rotated once they exceed MaxSize, keeping MaxFiles rotated
+// files.
+type Capture struct {
+	Options CaptureOptions
+
+	mu     sync.Mutex
+	out    io.Writer
+	file   *os.File
+	size   int64
+	random *rand.Rand
+}
+
+type CaptureOptions struct {
+	Path       string
+	MaxSize    int64
+	MaxFiles   int
+	Sample     float64
+	Namespaces []string
+	Log        logr.Logger
+}
+
+// CaptureRecord is a captured admission review. Request and Response are the
+// AdmissionReview objects received and returned by the webhook so that a
+// request can be used as a test fixture or replayed as is.
+type CaptureRecord struct {
+	Time     time.Time       `json:"time"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+func newCapture(o CaptureOptions) (*Capture, error) {
+	if o.Sample < 0 || o.Sample > 1 {
+		return nil, fmt.Errorf("%w: %v", ErrCaptureSampleInvalid, o.Sample)
+	}
+
+	c := &Capture{
+		Options: o,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if o.Path == CaptureStdout {
+		c.out = os.Stdout
+		return c, nil
+	}
+
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Middleware captures the admission reviews served by the handler. Capture
+// failures are logged and never affect the response.
+func (c *Capture) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "unable to read request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if !c.captures(body) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &captureResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		req, err := redact(body)
+		if err != nil {
+			c.Options.Log.Error(err, "Unable to redact admission review.")
+			return
+		}
+
+		rec := CaptureRecord{
+			Time:     time.Now().UTC(),
+			Path:     r.URL.Path,
+			Request:  req,
+			Response: rw.body.Bytes(),
+		}
+		if !json.Valid(rec.Response) {
+			rec.Response = nil
+		}
+
+		if err := c.write(rec); err != nil {
+			c.Options.Log.Error(err, "Unable to write captured admission review.")
+		}
+	})
+}
+
+// Close closes the capture file.
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+
+	return c.file.Close()
+}
+
+// captures reports whether the admission review is sampled and in one of the
+// captured namespaces.
+func (c *Capture) captures(body []byte) bool {
+	var ar struct {
+		Request struct {
+			Namespace string `json:"namespace"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(body, &ar); err != nil {
+		return false
+	}
+
+	if !matchesAny(c.Options.Namespaces, ar.Request.Namespace) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.random.Float64() < c.Options.Sample
+}
+
+func (c *Capture) write(rec CaptureRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("unable to marshal capture: %w", err)
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file != nil && c.Options.MaxSize > 0 && c.size+int64(len(line)) > c.Options.MaxSize && c.size > 0 {
+		if err := c.rotate(); err != nil {
+			c.Options.Log.Error(err, "Unable to rotate capture file.")
+		}
+	}
+
+	n, err := c.out.Write(line)
+	c.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to write capture: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Capture) open() error {
+	f, err := os.OpenFile(c.Options.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open capture file: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to stat capture file: %w", err)
+	}
+
+	c.file = f
+	c.out = f
+	c.size = fi.Size()
+
+	return nil
+}
+
+// rotate renames the capture file to path.1, shifting older files up and
+// removing those beyond MaxFiles, then opens a new capture file. The capture
+// file is reopened even when rotation fails so that captures continue in the
+// current file.
+func (c *Capture) rotate() error {
+	err := c.file.Close()
+	if err != nil {
+		err = fmt.Errorf("unable to close capture file: %w", err)
+	} else {
+		err = c.shift()
+	}
+
+	if oerr := c.open(); oerr != nil {
+		return oerr
+	}
+
+	return err
+}
+
+// shift renames the closed capture file to path.1, shifting older files up and
+// removing those beyond MaxFiles.
+func (c *Capture) shift() error {
+	name := func(idx int) string {
+		return c.Options.Path + "." + strconv.Itoa(idx)
+	}
+
+	if c.Options.MaxFiles < 1 {
+		if err := os.Remove(c.Options.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove capture file: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.Remove(name(c.Options.MaxFiles)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove capture file: %w", err)
+	}
+
+	for idx := c.Options.MaxFiles - 1; idx > 0; idx-- {
+		if err := os.Rename(name(idx), name(idx+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate capture file: %w", err)
+		}
+	}
+
+	if err := os.Rename(c.Options.Path, name(1)); err != nil {
+		return fmt.Errorf("unable to rotate capture file: %w", err)
+	}
+
+	return nil
+}
+
+// redact replaces the values of secret looking annotations of the object and
+// old object of an admission review.
+func redact(body []byte) ([]byte, error) {
+	var ar map[string]interface{}
+	if err := json.Unmarshal(body, &ar); err != nil {
+		return nil, fmt.Errorf("unable to decode admission review: %w", err)
+	}
+
+	req, _ := ar["request"].(map[string]interface{})
+	for _, key := range []string{"object", "oldObject"} {
+		obj, _ := req[key].(map[string]interface{})
+		redactAnnotations(obj)
+	}
+
+	out, err := json.Marshal(ar)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode admission review: %w", err)
+	}
+
+	return out, nil
+}
+
+// redactAnnotations replaces the values of secret looking annotations of an
+// object, including those of the last applied configuration. A last applied
+// configuration that cannot be parsed is redacted as a whole.
+func redactAnnotations(obj map[string]interface{}) {
+	meta, _ := obj["metadata"].(map[string]interface{})
+	anns, _ := meta["annotations"].(map[string]interface{})
+
+	for k, v := range anns {
+		if secretAnnotation.MatchString(k) {
+			anns[k] = redacted
+			continue
+		}
+
+		if k != lastAppliedAnnotation {
+			continue
+		}
+
+		var applied map[string]interface{}
+		s, _ := v.(string)
+		if err := json.Unmarshal([]byte(s), &applied); err != nil {
+			anns[k] = redacted
+			continue
+		}
+
+		redactAnnotations(applied)
+
+		out, err := json.Marshal(applied)
+		if err != nil {
+			anns[k] = redacted
+			continue
+		}
+		anns[k] = string(out) + "\n"
+	}
+}
+
+// captureResponseWriter keeps a copy of the response body.
+type captureResponseWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestCaptureMiddleware(t *testing.T) {
+	rules, err := os.ReadFile(filepath.Join(fixtures, "transforms.yaml"))
+	if err != nil {
+		t.Fatalf("unable to read rules: %v", err)
+	}
+
+	fixture := filepath.Join(fixtures, "admissionreview.json")
+
+	body, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatalf("unable to read fixture: %v", err)
+	}
+
+	secret := strings.Replace(string(body), `"metadata": {`, `"metadata": {
+        "annotations": {"example.com/api-token": "s3cr3t"},`, 1)
+
+	tests := map[string]struct {
+		body       string
+		sample     float64
+		namespaces []string
+		want       int
+	}{
+		"captured":     {body: string(body), sample: 1, want: 1},
+		"namespace":    {body: string(body), sample: 1, namespaces: []string{"def*"}, want: 1},
+		"filtered":     {body: string(body), sample: 1, namespaces: []string{"kube-*"}},
+		"not sampled":  {body: string(body)},
+		"redacted":     {body: secret, sample: 1, want: 1},
+		"not a review": {body: "{", sample: 1},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+			c, err := newCapture(CaptureOptions{
+				Path:       path,
+				Sample:     tc.sample,
+				Namespaces: tc.namespaces,
+				Log:        logr.Discard(),
+			})
+			if err != nil {
+				t.Fatalf("newCapture() error = %v", err)
+			}
+			defer c.Close()
+
+			wh, err := newWebhook(context.Background(), WebhookOptions{
+				Transformer: newTestTransforms(t, newTestConfigMap(string(rules))),
+				Metrics:     prometheus.NewRegistry(),
+				Log:         logr.Discard(),
+			})
+			if err != nil {
+				t.Fatalf("unable to create webhook: %v", err)
+			}
+
+			h := c.Middleware(wh.Handler())
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body)))
+
+			recs := readCaptures(t, path)
+			if len(recs) != tc.want {
+				t.Fatalf("captured %v records, want %v", len(recs), tc.want)
+			}
+			if tc.want == 0 {
+				return
+			}
+
+			var res admissionv1.AdmissionReview
+			if err := json.Unmarshal(recs[0].Response, &res); err != nil {
+				t.Fatalf("unable to unmarshal captured response: %v", err)
+			}
+			if res.Response == nil || !res.Response.Allowed {
+				t.Errorf("captured response = %v", string(recs[0].Response))
+			}
+
+			if strings.Contains(string(recs[0].Request), "s3cr3t") {
+				t.Errorf("captured request is not redacted")
+			}
+
+			// Captured requests are used as fixtures as is.
+			replay := filepath.Join(t.TempDir(), "replay.json")
+			if err := os.WriteFile(replay, recs[0].Request, 0o644); err != nil {
+				t.Fatalf("unable to write replay fixture: %v", err)
+			}
+
+			if diff := cmp.Diff(string(review(t, wh.Handler(), fixture)), string(review(t, wh.Handler(), replay))); diff != "" {
+				t.Errorf("replay mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	applied := `{"apiVersion":"networking.k8s.io/v1","kind":"Ingress","metadata":{"annotations":{"example.com/token":"s3cr3t","example.com/owner":"team"},"name":"muting"}}`
+
+	anns := map[string]string{
+		"example.com/api-key":                              "s3cr3t",
+		"example.com/db_password":                          "s3cr3t",
+		"example.com/auth":                                 "s3cr3t",
+		"nginx.ingress.kubernetes.io/auth-url":             "https://auth.example.org",
+		"nginx.ingress.kubernetes.io/auth-type":            "basic",
+		"nginx.ingress.kubernetes.io/auth-secret":          "s3cr3t",
+		"example.com/author":                               "team",
+		"example.com/tokenizer":                            "words",
+		"kubectl.kubernetes.io/last-applied-configuration": applied + "\n",
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"request": map[string]interface{}{
+			"object": map[string]interface{}{
+				"metadata": map[string]interface{}{"annotations": anns},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal admission review: %v", err)
+	}
+
+	out, err := redact(body)
+	if err != nil {
+		t.Fatalf("redact() error = %v", err)
+	}
+
+	var got struct {
+		Request struct {
+			Object struct {
+				Metadata struct {
+					Annotations map[string]string `json:"annotations"`
+				} `json:"metadata"`
+			} `json:"object"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("unable to unmarshal redacted admission review: %v", err)
+	}
+
+	want := map[string]string{
+		"example.com/api-key":                              redacted,
+		"example.com/db_password":                          redacted,
+		"example.com/auth":                                 redacted,
+		"nginx.ingress.kubernetes.io/auth-url":             "https://auth.example.org",
+		"nginx.ingress.kubernetes.io/auth-type":            "basic",
+		"nginx.ingress.kubernetes.io/auth-secret":          redacted,
+		"example.com/author":                               "team",
+		"example.com/tokenizer":                            "words",
+		"kubectl.kubernetes.io/last-applied-configuration": `{"apiVersion":"networking.k8s.io/v1","kind":"Ingress","metadata":{"annotations":{"example.com/owner":"team","example.com/token":"REDACTED"},"name":"muting"}}` + "\n",
+	}
+
+	if diff := cmp.Diff(want, got.Request.Object.Metadata.Annotations); diff != "" {
+		t.Errorf("redact() mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestCaptureRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+	c, err := newCapture(CaptureOptions{
+		Path:     path,
+		MaxSize:  1,
+		MaxFiles: 2,
+		Sample:   1,
+		Log:      logr.Discard(),
+	})
+	if err != nil {
+		t.Fatalf("newCapture() error = %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := c.write(CaptureRecord{Path: "/", Request: json.RawMessage("{}"), Response: json.RawMessage("{}")}); err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if got := len(readCaptures(t, name)); got != 1 {
+			t.Errorf("%v has %v records, want 1", filepath.Base(name), got)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("rotated file beyond max files exists: %v", err)
+	}
+}
+
+func TestCaptureRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+	// A non-empty directory in place of the rotated file cannot be removed.
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0o755); err != nil {
+		t.Fatalf("unable to create rotated file directory: %v", err)
+	}
+
+	c, err := newCapture(CaptureOptions{
+		Path:     path,
+		MaxSize:  1,
+		MaxFiles: 1,
+		Sample:   1,
+		Log:      logr.Discard(),
+	})
+	if err != nil {
+		t.Fatalf("newCapture() error = %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := c.write(CaptureRecord{Path: "/", Request: json.RawMessage("{}"), Response: json.RawMessage("{}")}); err != nil {
+			t.Fatalf("write() error = %v", err)
+		}
+	}
+
+	if got := len(readCaptures(t, path)); got != 3 {
+		t.Errorf("%v has %v records, want 3", filepath.Base(path), got)
+	}
+}
+
+func TestCaptureSampleInvalid(t *testing.T) {
+	if _, err := newCapture(CaptureOptions{Path: CaptureStdout, Sample: 2}); err == nil {
+		t.Errorf("newCapture() error = nil, want %v", ErrCaptureSampleInvalid)
+	}
+}
+
+func readCaptures(t *testing.T, path string) []CaptureRecord {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read captures: %v", err)
+	}
+
+	var recs []CaptureRecord
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var rec CaptureRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			t.Fatalf("unable to unmarshal capture: %v", err)
+		}
+		recs = append(recs, rec)
+	}
+
+	return recs
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// certificateGVK identifies cert-manager Certificates. They are handled as
+// unstructured objects so that the cert-manager module is not required.
+var certificateGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
+func isCertificate(u *unstructured.Unstructured) bool {
+	return u.GroupVersionKind() == certificateGVK
+}
+
+// transformCertificate rewrites the DNS names and common name of a
+// Certificate in place and returns each distinct change. Certificates have no
+// ingress class so rules restricted to ingress classes do not apply.
+func transformCertificate(ctx context.Context, t Transformer, namespace string, u *unstructured.Unstructured, o ingressOptions) ([]HostChange, error) {
+	r := newRewrites()
+	transform := r.transformer(ctx, t, namespace, o)
+
+	names, ok, err := unstructured.NestedStringSlice(u.Object, "spec", "dnsNames")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get dns names: %w", err)
+	}
+	if ok {
+		for idx, name := range names {
+			host, err := transform(name)
+			if err != nil {
+				return nil, err
+			}
+			names[idx] = host
+		}
+
+		if err := unstructured.SetNestedStringSlice(u.Object, names, "spec", "dnsNames"); err != nil {
+			return nil, fmt.Errorf("unable to set dns names: %w", err)
+		}
+	}
+
+	cn, ok, err := unstructured.NestedString(u.Object, "spec", "commonName")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get common name: %w", err)
+	}
+	if ok && cn != "" {
+		host, err := transform(cn)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := unstructured.SetNestedField(u.Object, host, "spec", "commonName"); err != nil {
+			return nil, fmt.Errorf("unable to set common name: %w", err)
+		}
+	}
+
+	return r.changes, nil
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	typednetworkingv1 "k8s.io/client-go/kubernetes/typed/networking/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// legacyClassAnnotation selects the ingress class of Ingresses that predate
+// spec.ingressClassName.
+const legacyClassAnnotation = "kubernetes.io/ingress.class"
+
+var ErrIngressClassesNotSynced = errors.New("ingress classes not synced")
+
+// ClassOptions restricts the webhook to Ingresses of the listed classes. The
+// lister is used to resolve the default IngressClass of the cluster.
+type ClassOptions struct {
+	Classes []string
+	Lister  networkinglisters.IngressClassLister
+}
+
+// newIngressClassLister runs an IngressClass informer and waits for it to
+// sync.
+func newIngressClassLister(ctx context.Context, client typednetworkingv1.IngressClassesGetter) (networkinglisters.IngressClassLister, error) {
+	lw := &cache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			return client.IngressClasses().List(ctx, o)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			return client.IngressClasses().Watch(ctx, o)
+		},
+	}
+
+	inf := cache.NewSharedIndexInformer(lw, &networkingv1.IngressClass{}, informerResync, cache.Indexers{})
+	go inf.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), inf.HasSynced) {
+		return nil, ErrIngressClassesNotSynced
+	}
+
+	return networkinglisters.NewIngressClassLister(inf.GetIndexer()), nil
+}
+
+// resolve returns the ingress class of an Ingress from its spec, the legacy
+// annotation or, when fallback is set, the default IngressClass, in that
+// order. An empty class is returned when none applies.
+func (o ClassOptions) resolve(ing *networkingv1.Ingress, fallback bool) (string, error) {
+	if ing.Spec.IngressClassName != nil && *ing.Spec.IngressClassName != "" {
+		return *ing.Spec.IngressClassName, nil
+	}
+
+	if class := ing.Annotations[legacyClassAnnotation]; class != "" {
+		return class, nil
+	}
+
+	if !fallback || o.Lister == nil {
+		return "", nil
+	}
+
+	list, err := o.Lister.List(labels.Everything())
+	if err != nil {
+		return "", fmt.Errorf("unable to list ingress classes: %w", err)
+	}
+
+	// The API server resolves multiple defaults to the most recently created
+	// class and so do we.
+	var def *networkingv1.IngressClass
+	for _, ic := range list {
+		if ic.Annotations[networkingv1.AnnotationIsDefaultIngressClass] != "true" {
+			continue
+		}
+		if def == nil || def.CreationTimestamp.Before(&ic.CreationTimestamp) {
+			def = ic
+		}
+	}
+
+	if def == nil {
+		return "", nil
+	}
+
+	return def.Name, nil
+}
+
+// allows reports whether Ingresses of the class are reviewed. Every class is
+// allowed when none are listed.
+func (o ClassOptions) allows(class string) bool {
+	return len(o.Classes) == 0 || contains(o.Classes, class)
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestIngressClass(name string, def bool, created time.Time) *networkingv1.IngressClass {
+	ic := &networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(created),
+		},
+	}
+	if def {
+		ic.Annotations = map[string]string{networkingv1.AnnotationIsDefaultIngressClass: "true"}
+	}
+
+	return ic
+}
+
+func newTestIngressClassLister(t *testing.T, classes ...*networkingv1.IngressClass) networkinglisters.IngressClassLister {
+	t.Helper()
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, ic := range classes {
+		if err := indexer.Add(ic); err != nil {
+			t.Fatalf("unable to add ingress class: %v", err)
+		}
+	}
+
+	return networkinglisters.NewIngressClassLister(indexer)
+}
+
+func TestClassResolve(t *testing.T) {
+	now := time.Now()
+	public := "public"
+
+	tests := map[string]struct {
+		ing        *networkingv1.Ingress
+		classes    []*networkingv1.IngressClass
+		noFallback bool
+		want       string
+	}{
+		"spec": {
+			ing: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{legacyClassAnnotation: "private"}},
+				Spec:       networkingv1.IngressSpec{IngressClassName: &public},
+			},
+			want: "public",
+		},
+		"annotation": {
+			ing: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{legacyClassAnnotation: "private"}},
+			},
+			classes: []*networkingv1.IngressClass{newTestIngressClass("public", true, now)},
+			want:    "private",
+		},
+		"default": {
+			ing: &networkingv1.Ingress{},
+			classes: []*networkingv1.IngressClass{
+				newTestIngressClass("private", false, now),
+				newTestIngressClass("public", true, now),
+			},
+			want: "public",
+		},
+		"newest default": {
+			ing: &networkingv1.Ingress{},
+			classes: []*networkingv1.IngressClass{
+				newTestIngressClass("public", true, now.Add(-time.Hour)),
+				newTestIngressClass("private", true, now),
+			},
+			want: "private",
+		},
+		"default not needed": {
+			ing:        &networkingv1.Ingress{},
+			classes:    []*networkingv1.IngressClass{newTestIngressClass("public", true, now)},
+			noFallback: true,
+		},
+		"no default": {
+			ing:     &networkingv1.Ingress{},
+			classes: []*networkingv1.IngressClass{newTestIngressClass("public", false, now)},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ClassOptions{Lister: newTestIngressClassLister(t, tc.classes...)}.resolve(tc.ing, !tc.noFallback)
+			if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("resolve() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMutatorClasses(t *testing.T) {
+	const rules = `
+- name: public
+  from: [example.org]
+  to: example.net
+  ingressClasses: [public]
+- name: other
+  from: [example.com]
+  to: example.net
+`
+
+	tests := map[string]struct {
+		classes     []string
+		class       string
+		host        string
+		wantHost    string
+		wantSkipped float64
+	}{
+		"unfiltered": {
+			class:    "private",
+			host:     "a.example.com",
+			wantHost: "a.example.net",
+		},
+		"global match": {
+			classes:  []string{"public"},
+			class:    "public",
+			host:     "a.example.org",
+			wantHost: "a.example.net",
+		},
+		"global skip": {
+			classes:     []string{"public"},
+			class:       "private",
+			host:        "a.example.com",
+			wantHost:    "a.example.com",
+			wantSkipped: 1,
+		},
+		"rule skip": {
+			class:    "private",
+			host:     "a.example.org",
+			wantHost: "a.example.org",
+		},
+		"rule default class": {
+			host:     "a.example.org",
+			wantHost: "a.example.net",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			m, err := newWebhookMetrics(prometheus.NewRegistry())
+			if err != nil {
+				t.Fatalf("newWebhookMetrics() error = %v", err)
+			}
+
+			mutate := mutatorFunc(WebhookOptions{
+				Transformer: newTestTransforms(t, newTestConfigMap(rules)),
+				Classes: ClassOptions{
+					Classes: tc.classes,
+					Lister:  newTestIngressClassLister(t, newTestIngressClass("public", true, time.Now())),
+				},
+				Events:     noopEventRecorder{},
+				Collisions: noopCollisionChecker{},
+				Log:        logr.Discard(),
+			}, m)
+
+			ing := &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "muting"},
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: tc.host}},
+				},
+			}
+			if tc.class != "" {
+				ing.Spec.IngressClassName = &tc.class
+			}
+
+			ar := &kwhmodel.AdmissionReview{Namespace: "default", Name: "muting"}
+
+			if _, err := mutate(context.Background(), ar, ing); err != nil {
+				t.Fatalf("mutate() error = %v", err)
+			}
+
+			if got := ing.Spec.Rules[0].Host; got != tc.wantHost {
+				t.Errorf("host = %v, want %v", got, tc.wantHost)
+			}
+
+			if got := testutil.ToFloat64(m.Skipped.WithLabelValues("default", tc.class)); got != tc.wantSkipped {
+				t.Errorf("skipped = %v, want %v", got, tc.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+type Collisions struct {
+	Informer cache.SharedIndexInformer
+	Synced   *HealthGate
+	Options  CollisionsOptions
+}
+
+type CollisionsOptions struct {
+	Policy string
+	Resync time.Duration
+	Client kubernetes.Interface
+}
+
+type Collision struct {
+	Host      string
+	Namespace string
+	Name      string
+}
+
+const (
+	CollisionPolicyIgnore = "ignore"
+	CollisionPolicyWarn   = "warn"
+	CollisionPolicyDeny   = "deny"
+
+	hostIndex = "host"
+)
+
+var (
+	ErrHostCollision          = errors.New("host collision")
+	ErrCollisionPolicyUnknown = errors.New("unknown collision policy")
+	ErrIngressesNotSynced     = errors.New("ingresses not synced")
+)
+
+func newCollisions(o CollisionsOptions) (*Collisions, error) {
+	switch o.Policy {
+	case CollisionPolicyIgnore, CollisionPolicyWarn, CollisionPolicyDeny:
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrCollisionPolicyUnknown, o.Policy)
+	}
+
+	f := informers.NewSharedInformerFactory(o.Client, o.Resync)
+	inf := f.Networking().V1().Ingresses().Informer()
+
+	if err := inf.AddIndexers(cache.Indexers{hostIndex: ingressHosts}); err != nil {
+		return nil, fmt.Errorf("unable to add host indexer: %w", err)
+	}
+
+	return &Collisions{
+		Informer: inf,
+		Synced:   newHealthGate(ErrIngressesNotSynced),
+		Options:  o,
+	}, nil
+}
+
+func (c *Collisions) Start(ctx context.Context) error {
+	go c.Informer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.Informer.HasSynced) {
+		return ErrIngressesNotSynced
+	}
+
+	c.Synced.Set(nil)
+
+	return nil
+}
+
+// Check returns warnings for hosts already claimed by an Ingress in another
+// namespace, or an error if the policy denies them.
+func (c *Collisions) Check(ctx context.Context, namespace string, hosts []string) ([]string, error) {
+	_, span := otel.Tracer(name).Start(ctx, "CheckCollisions")
+	defer span.End()
+
+	if c.Options.Policy == CollisionPolicyIgnore {
+		return nil, nil
+	}
+
+	var cs []Collision
+	for _, host := range hosts {
+		objs, err := c.Informer.GetIndexer().ByIndex(hostIndex, host)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, fmt.Errorf("unable to query host index: %w", err)
+		}
+
+		for _, obj := range objs {
+			ing, ok := obj.(*networkingv1.Ingress)
+			if !ok || ing.Namespace == namespace {
+				continue
+			}
+
+			cs = append(cs, Collision{
+				Host:      host,
+				Namespace: ing.Namespace,
+				Name:      ing.Name,
+			})
+		}
+	}
+
+	if len(cs) == 0 {
+		return nil, nil
+	}
+
+	var warnings []string
+	for _, col := range cs {
+		warnings = append(warnings, col.String())
+	}
+
+	if c.Options.Policy == CollisionPolicyDeny {
+		err := fmt.Errorf("%w: %v", ErrHostCollision, strings.Join(warnings, ", "))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	return warnings, nil
+}
+
+func (c Collision) String() string {
+	return fmt.Sprintf("host %v is already used by ingress %v/%v", c.Host, c.Namespace, c.Name)
+}
+
+func ingressHosts(obj interface{}) ([]string, error) {
+	ing, ok := obj.(*networkingv1.Ingress)
+	if !ok {
+		return nil, nil
+	}
+
+	var hosts []string
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host != "" {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+
+	return hosts, nil
+}
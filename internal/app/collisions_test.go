@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollisionsCheck(t *testing.T) {
+	existing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "web"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{Host: "web.example.net"}},
+		},
+	}
+
+	tests := map[string]struct {
+		policy    string
+		namespace string
+		hosts     []string
+		want      []string
+		wantErr   error
+	}{
+		"warn": {
+			policy:    CollisionPolicyWarn,
+			namespace: "default",
+			hosts:     []string{"web.example.net", "api.example.net"},
+			want:      []string{"host web.example.net is already used by ingress team/web"},
+		},
+		"deny": {
+			policy:    CollisionPolicyDeny,
+			namespace: "default",
+			hosts:     []string{"web.example.net"},
+			wantErr:   ErrHostCollision,
+		},
+		"deny without collision": {
+			policy:    CollisionPolicyDeny,
+			namespace: "default",
+			hosts:     []string{"api.example.net"},
+		},
+		"same namespace": {
+			policy:    CollisionPolicyDeny,
+			namespace: "team",
+			hosts:     []string{"web.example.net"},
+		},
+		"ignore": {
+			policy:    CollisionPolicyIgnore,
+			namespace: "default",
+			hosts:     []string{"web.example.net"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			c, err := newCollisions(CollisionsOptions{
+				Policy: tc.policy,
+				Client: fake.NewSimpleClientset(existing),
+			})
+			if err != nil {
+				t.Fatalf("newCollisions() error = %v", err)
+			}
+
+			if err := c.Start(ctx); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			got, err := c.Check(ctx, tc.namespace, tc.hosts)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Check() mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestCollisionsPolicyUnknown(t *testing.T) {
+	_, err := newCollisions(CollisionsOptions{Policy: "explode", Client: fake.NewSimpleClientset()})
+	if !errors.Is(err, ErrCollisionPolicyUnknown) {
+		t.Errorf("newCollisions() error = %v, want %v", err, ErrCollisionPolicyUnknown)
+	}
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventReasonRewritten       = "HostsRewritten"
+	eventReasonTransformFailed = "TransformFailed"
+)
+
+var ErrEventObjectUnknown = errors.New("event object has no name")
+
+type Events struct {
+	Client      kubernetes.Interface
+	Recorder    record.EventRecorder
+	Broadcaster record.EventBroadcaster
+	Options     EventsOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type EventsOptions struct {
+	Component string
+	Backoff   wait.Backoff
+	Timeout   time.Duration
+	Client    kubernetes.Interface
+	Log       logr.Logger
+}
+
+type HostChange struct {
+	Rule   string
+	From   string
+	To     string
+	Shadow bool
+}
+
+func newEvents(o EventsOptions) *Events {
+	b := record.NewBroadcaster()
+	b.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: o.Client.CoreV1().Events(""),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Events{
+		Client:      o.Client,
+		Broadcaster: b,
+		Recorder:    b.NewRecorder(scheme.Scheme, corev1.EventSource{Component: o.Component}),
+		Options:     o,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+func (e *Events) Rewritten(ar *kwhmodel.AdmissionReview, changes []HostChange) {
+	if ar.DryRun || len(changes) == 0 {
+		return
+	}
+
+	var strs []string
+	for _, c := range changes {
+		strs = append(strs, c.String())
+	}
+	msg := fmt.Sprintf("Rewrote hosts: %v", strings.Join(strs, ", "))
+
+	if e.ctx.Err() != nil {
+		return
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.emit(ar, changes, corev1.EventTypeNormal, eventReasonRewritten, msg)
+	}()
+}
+
+func (e *Events) Failed(ar *kwhmodel.AdmissionReview, err error) {
+	if ar.DryRun {
+		return
+	}
+
+	msg := fmt.Sprintf("Unable to transform hosts: %v", err)
+
+	// A rejected object is never stored so the event refers to the object
+	// under review instead of waiting for it to exist.
+	ref := admissionReference(ar)
+	if ref.Name == "" {
+		return
+	}
+
+	e.Recorder.Event(ref, corev1.EventTypeWarning, eventReasonTransformFailed, msg)
+
+	e.Options.Log.V(1).Info("Recorded event.",
+		"uid", ar.ID,
+		"namespace", ar.Namespace,
+		"name", ref.Name,
+		"reason", eventReasonTransformFailed,
+	)
+}
+
+// admissionReference refers to the object of an admission review using the
+// generated name prefix when the object has no name yet.
+func admissionReference(ar *kwhmodel.AdmissionReview) *corev1.ObjectReference {
+	ref := &corev1.ObjectReference{
+		Namespace: ar.Namespace,
+		Name:      ar.Name,
+	}
+
+	if gvk := ar.RequestGVK; gvk != nil {
+		ref.Kind = gvk.Kind
+		ref.APIVersion = schema.GroupVersion{Group: gvk.Group, Version: gvk.Version}.String()
+	}
+
+	var obj metav1.PartialObjectMetadata
+	if err := json.Unmarshal(ar.NewObjectRaw, &obj); err == nil {
+		ref.UID = obj.UID
+		if ref.Name == "" {
+			ref.Name = obj.Name
+		}
+		if ref.Name == "" {
+			ref.Name = obj.GenerateName
+		}
+	}
+
+	return ref
+}
+
+// Shutdown cancels the events waiting for their object and waits for them to
+// return before stopping the broadcaster, which panics on events recorded
+// after it is stopped.
+func (e *Events) Shutdown() {
+	e.cancel()
+	e.wg.Wait()
+	e.Broadcaster.Shutdown()
+}
+
+// emit waits for the object to be persisted before recording the event as
+// admission happens before the object exists on create.
+func (e *Events) emit(ar *kwhmodel.AdmissionReview, changes []HostChange, eventtype, reason, msg string) {
+	ctx, cancel := context.WithTimeout(e.ctx, e.Options.Timeout)
+	defer cancel()
+
+	ctx, span := otel.Tracer(name).Start(ctx, "emitEvent")
+	defer span.End()
+
+	ref := admissionReference(ar)
+
+	var obj runtime.Object
+
+	err := wait.ExponentialBackoffWithContext(ctx, e.Options.Backoff, func() (bool, error) {
+		ing, err := e.find(ctx, ar, ref, changes)
+		if errors.Is(err, ErrEventObjectUnknown) {
+			return false, err
+		}
+		if err != nil || ing == nil {
+			return false, nil
+		}
+
+		obj = ing
+
+		return true, nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		e.Options.Log.Error(err, "Unable to find object for event.",
+			"uid", ar.ID,
+			"namespace", ar.Namespace,
+			"name", ref.Name,
+			"reason", reason,
+		)
+		return
+	}
+
+	e.Recorder.Event(obj, eventtype, reason, msg)
+
+	e.Options.Log.V(1).Info("Recorded event.",
+		"uid", ar.ID,
+		"namespace", ar.Namespace,
+		"name", ref.Name,
+		"reason", reason,
+	)
+}
+
+// find returns the persisted Ingress of an admission review, or nil when it
+// does not exist yet. An Ingress created with a generated name is found by its
+// UID when the review has one, otherwise by the newest Ingress with the same
+// name prefix holding every rewritten host.
+func (e *Events) find(ctx context.Context, ar *kwhmodel.AdmissionReview, ref *corev1.ObjectReference, changes []HostChange) (*networkingv1.Ingress, error) {
+	cl := e.Client.NetworkingV1().Ingresses(ar.Namespace)
+
+	if ar.Name != "" {
+		return cl.Get(ctx, ar.Name, metav1.GetOptions{})
+	}
+
+	if ref.Name == "" {
+		return nil, ErrEventObjectUnknown
+	}
+
+	list, err := cl.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var found *networkingv1.Ingress
+	for idx := range list.Items {
+		ing := &list.Items[idx]
+
+		if ref.UID != "" && ing.UID != ref.UID {
+			continue
+		}
+		if ref.UID == "" && (ing.GenerateName != ref.Name || !holdsHosts(ing, changes)) {
+			continue
+		}
+
+		if found == nil || found.CreationTimestamp.Before(&ing.CreationTimestamp) {
+			found = ing
+		}
+	}
+
+	return found, nil
+}
+
+// holdsHosts reports whether the rule hosts of an Ingress include every host
+// applied by the changes.
+func holdsHosts(ing *networkingv1.Ingress, changes []HostChange) bool {
+	hosts := make(map[string]bool)
+	for _, rule := range ing.Spec.Rules {
+		hosts[rule.Host] = true
+	}
+	for _, tls := range ing.Spec.TLS {
+		for _, h := range tls.Hosts {
+			hosts[h] = true
+		}
+	}
+
+	for _, c := range changes {
+		if !c.Shadow && !hosts[c.To] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c HostChange) String() string {
+	return fmt.Sprintf("%v => %v (rule %v)", c.From, c.To, c.Rule)
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// refRecorder keeps the object reference of every recorded event.
+type refRecorder struct {
+	*record.FakeRecorder
+	refs []*corev1.ObjectReference
+}
+
+func (r *refRecorder) Event(obj runtime.Object, eventtype, reason, msg string) {
+	if ref, ok := obj.(*corev1.ObjectReference); ok {
+		r.refs = append(r.refs, ref)
+	}
+	r.FakeRecorder.Event(obj, eventtype, reason, msg)
+}
+
+func TestEventsFailed(t *testing.T) {
+	gvk := &metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+
+	tests := map[string]struct {
+		name string
+		raw  string
+		want corev1.ObjectReference
+	}{
+		"name": {
+			name: "muting",
+			raw:  `{"metadata":{"name":"muting","uid":"1234"}}`,
+			want: corev1.ObjectReference{
+				Kind:       "Ingress",
+				APIVersion: "networking.k8s.io/v1",
+				Namespace:  "default",
+				Name:       "muting",
+				UID:        "1234",
+			},
+		},
+		"generate name": {
+			raw: `{"metadata":{"generateName":"muting-"}}`,
+			want: corev1.ObjectReference{
+				Kind:       "Ingress",
+				APIVersion: "networking.k8s.io/v1",
+				Namespace:  "default",
+				Name:       "muting-",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := &refRecorder{FakeRecorder: record.NewFakeRecorder(1)}
+			ev := &Events{
+				Recorder: rec,
+				Options:  EventsOptions{Log: logr.Discard()},
+			}
+
+			ev.Failed(&kwhmodel.AdmissionReview{
+				Namespace:    "default",
+				Name:         tc.name,
+				Operation:    kwhmodel.OperationCreate,
+				RequestGVK:   gvk,
+				NewObjectRaw: []byte(tc.raw),
+			}, errors.New("failed"))
+
+			if len(rec.refs) != 1 {
+				t.Fatalf("recorded %v events, want 1", len(rec.refs))
+			}
+
+			if got := <-rec.Events; !strings.Contains(got, eventReasonTransformFailed) {
+				t.Errorf("event = %v, want reason %v", got, eventReasonTransformFailed)
+			}
+
+			if diff := cmp.Diff(tc.want, *rec.refs[0]); diff != "" {
+				t.Errorf("involved object mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestEventsRewritten(t *testing.T) {
+	changes := []HostChange{{Rule: "example", From: "a.example.org", To: "a.example.net"}}
+
+	newIngress := func(name, generateName, host string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:    "default",
+				Name:         name,
+				GenerateName: generateName,
+			},
+			Spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{Host: host}},
+			},
+		}
+	}
+
+	tests := map[string]struct {
+		name     string
+		raw      string
+		existing []runtime.Object
+		want     string
+	}{
+		"name": {
+			name:     "muting",
+			raw:      `{"metadata":{"name":"muting"}}`,
+			existing: []runtime.Object{newIngress("muting", "", "a.example.net")},
+			want:     "muting",
+		},
+		"generate name": {
+			raw: `{"metadata":{"generateName":"muting-"}}`,
+			existing: []runtime.Object{
+				newIngress("muting-other", "muting-", "b.example.net"),
+				newIngress("muting-abcde", "muting-", "a.example.net"),
+			},
+			want: "muting-abcde",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ev := newEvents(EventsOptions{
+				Backoff: wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1, Steps: 10},
+				Timeout: time.Minute,
+				Client:  fake.NewSimpleClientset(tc.existing...),
+				Log:     logr.Discard(),
+			})
+			rec := &objRecorder{FakeRecorder: record.NewFakeRecorder(1)}
+			ev.Recorder = rec
+
+			ev.Rewritten(&kwhmodel.AdmissionReview{
+				Namespace:    "default",
+				Name:         tc.name,
+				Operation:    kwhmodel.OperationCreate,
+				NewObjectRaw: []byte(tc.raw),
+			}, changes)
+
+			select {
+			case <-rec.Events:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no event recorded")
+			}
+			ev.Shutdown()
+
+			if len(rec.names) != 1 {
+				t.Fatalf("recorded %v events, want 1", len(rec.names))
+			}
+
+			if rec.names[0] != tc.want {
+				t.Errorf("event object = %v, want %v", rec.names[0], tc.want)
+			}
+		})
+	}
+}
+
+func TestEventsShutdown(t *testing.T) {
+	ev := newEvents(EventsOptions{
+		Backoff: wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1, Steps: 1000},
+		Timeout: time.Minute,
+		Client:  fake.NewSimpleClientset(),
+		Log:     logr.Discard(),
+	})
+
+	ev.Rewritten(&kwhmodel.AdmissionReview{
+		Namespace: "default",
+		Name:      "missing",
+		Operation: kwhmodel.OperationCreate,
+	}, []HostChange{{Rule: "example", From: "a.example.org", To: "a.example.net"}})
+
+	done := make(chan struct{})
+	go func() {
+		ev.Shutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() did not cancel pending events")
+	}
+
+	// Events after shutdown are dropped rather than sent to the stopped
+	// broadcaster.
+	ev.Rewritten(&kwhmodel.AdmissionReview{Namespace: "default", Name: "late"}, []HostChange{{To: "a.example.net"}})
+}
+
+// objRecorder keeps the name of the object of every recorded event.
+type objRecorder struct {
+	*record.FakeRecorder
+	names []string
+}
+
+func (r *objRecorder) Event(obj runtime.Object, eventtype, reason, msg string) {
+	if o, ok := obj.(metav1.Object); ok {
+		r.names = append(r.names, o.GetName())
+	}
+	r.FakeRecorder.Event(obj, eventtype, reason, msg)
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Health struct {
+	Options HealthOptions
+
+	mu        sync.RWMutex
+	readiness []HealthCheck
+	liveness  []HealthCheck
+}
+
+type HealthOptions struct {
+	Timeout time.Duration
+}
+
+type HealthCheck struct {
+	Name  string
+	Check func(context.Context) error
+}
+
+type HealthStatus struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckStatus `json:"checks"`
+}
+
+type HealthCheckStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	healthStatusOK     = "ok"
+	healthStatusFailed = "failed"
+)
+
+func newHealth(o HealthOptions) *Health {
+	return &Health{
+		Options: o,
+	}
+}
+
+func (h *Health) AddReadinessCheck(name string, check func(context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.readiness = append(h.readiness, HealthCheck{Name: name, Check: check})
+}
+
+func (h *Health) AddLivenessCheck(name string, check func(context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.liveness = append(h.liveness, HealthCheck{Name: name, Check: check})
+}
+
+func (h *Health) ReadinessHandler() http.Handler {
+	return h.handler(func() []HealthCheck {
+		return h.readiness
+	})
+}
+
+func (h *Health) LivenessHandler() http.Handler {
+	return h.handler(func() []HealthCheck {
+		return h.liveness
+	})
+}
+
+func (h *Health) handler(checks func() []HealthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), h.Options.Timeout)
+		defer cancel()
+
+		h.mu.RLock()
+		status := runHealthChecks(ctx, checks())
+		h.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if status.Status != healthStatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+func runHealthChecks(ctx context.Context, checks []HealthCheck) HealthStatus {
+	status := HealthStatus{
+		Status: healthStatusOK,
+		Checks: []HealthCheckStatus{},
+	}
+
+	for _, c := range checks {
+		cs := HealthCheckStatus{
+			Name:   c.Name,
+			Status: healthStatusOK,
+		}
+
+		if err := c.Check(ctx); err != nil {
+			cs.Status = healthStatusFailed
+			cs.Error = err.Error()
+			status.Status = healthStatusFailed
+		}
+
+		status.Checks = append(status.Checks, cs)
+	}
+
+	return status
+}
+
+// HealthGate is a check whose result is set by the caller once a startup
+// step has completed.
+type HealthGate struct {
+	mu  sync.RWMutex
+	err error
+}
+
+func newHealthGate(err error) *HealthGate {
+	return &HealthGate{err: err}
+}
+
+func (g *HealthGate) Set(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.err = err
+}
+
+func (g *HealthGate) Check(context.Context) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.err
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthHandler(t *testing.T) {
+	gate := newHealthGate(ErrTransformsNotLoaded)
+
+	tests := map[string]struct {
+		checks     map[string]func(context.Context) error
+		open       bool
+		wantCode   int
+		wantStatus HealthStatus
+	}{
+		"no checks": {
+			wantCode:   http.StatusOK,
+			wantStatus: HealthStatus{Status: healthStatusOK, Checks: []HealthCheckStatus{}},
+		},
+		"failed": {
+			checks: map[string]func(context.Context) error{
+				"transforms": gate.Check,
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantStatus: HealthStatus{
+				Status: healthStatusFailed,
+				Checks: []HealthCheckStatus{
+					{Name: "transforms", Status: healthStatusFailed, Error: ErrTransformsNotLoaded.Error()},
+				},
+			},
+		},
+		"gate set": {
+			checks: map[string]func(context.Context) error{
+				"transforms": newHealthGate(nil).Check,
+			},
+			wantCode: http.StatusOK,
+			wantStatus: HealthStatus{
+				Status: healthStatusOK,
+				Checks: []HealthCheckStatus{{Name: "transforms", Status: healthStatusOK}},
+			},
+		},
+		"timeout": {
+			checks: map[string]func(context.Context) error{
+				"slow": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantStatus: HealthStatus{
+				Status: healthStatusFailed,
+				Checks: []HealthCheckStatus{
+					{Name: "slow", Status: healthStatusFailed, Error: context.DeadlineExceeded.Error()},
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newHealth(HealthOptions{Timeout: 10 * time.Millisecond})
+			for n, c := range tc.checks {
+				h.AddReadinessCheck(n, c)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tc.wantCode {
+				t.Errorf("status code = %v, want %v", rec.Code, tc.wantCode)
+			}
+
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("content type = %v, want application/json", got)
+			}
+
+			var got HealthStatus
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("unable to decode health status: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.wantStatus, got); diff != "" {
+				t.Errorf("health status mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestHealthGate(t *testing.T) {
+	g := newHealthGate(ErrTransformsNotLoaded)
+
+	if err := g.Check(context.Background()); !errors.Is(err, ErrTransformsNotLoaded) {
+		t.Errorf("Check() error = %v, want %v", err, ErrTransformsNotLoaded)
+	}
+
+	g.Set(nil)
+
+	if err := g.Check(context.Background()); err != nil {
+		t.Errorf("Check() error = %v, want nil", err)
+	}
+}
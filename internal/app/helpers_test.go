@@ -0,0 +1,46 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace = "muting"
+	testName      = "muting"
+)
+
+func newTestConfigMap(data string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: testNamespace,
+			Name:      testName,
+		},
+		Data: map[string]string{
+			"transforms": data,
+		},
+	}
+}
+
+func newTestTransforms(t testing.TB, objs ...runtime.Object) *Transforms {
+	t.Helper()
+
+	ts, err := newTransformer(TransformOptions{
+		Namespace: testNamespace,
+		Name:      testName,
+		Client:    fake.NewSimpleClientset(objs...).CoreV1(),
+		Metrics:   prometheus.NewRegistry(),
+		Log:       logr.Discard(),
+	})
+	if err != nil {
+		t.Fatalf("unable to create transformer: %v", err)
+	}
+
+	return ts
+}
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+var ErrIngressClassConflict = errors.New("conflicting ingress class")
+
+// ingressOptions selects the annotations holding hosts, the resolved ingress
+// class rules are filtered by, whether changes are shadowed for every rule and
+// the hosts of the previous version of an updated object.
+type ingressOptions struct {
+	Annotations []string
+	Class       string
+	Shadow      bool
+	DryRun      bool
+	Previous    previousHosts
+}
+
+// transformIngress rewrites the rule, TLS and annotation hosts of an Ingress
+// in place and returns each distinct change. Changes that are shadowed, either
+// for every rule or by the matching rule, are returned but not applied. Rules
+// that rewrite a host also set their ingress class and default annotations.
+func transformIngress(ctx context.Context, t Transformer, namespace string, ing *networkingv1.Ingress, o ingressOptions) ([]HostChange, error) {
+	r := newRewrites()
+	transform := r.transformer(ctx, t, namespace, o)
+
+	for idx, rule := range ing.Spec.Rules {
+		host, err := transform(rule.Host)
+		if err != nil {
+			return nil, err
+		}
+		ing.Spec.Rules[idx].Host = host
+	}
+
+	for idx, tls := range ing.Spec.TLS {
+		for hidx, h := range tls.Hosts {
+			host, err := transform(h)
+			if err != nil {
+				return nil, err
+			}
+			ing.Spec.TLS[idx].Hosts[hidx] = host
+		}
+	}
+
+	for _, key := range o.Annotations {
+		v, ok := ing.Annotations[key]
+		if !ok {
+			continue
+		}
+
+		hosts, err := transformList(v, transform)
+		if err != nil {
+			return nil, fmt.Errorf("annotation %v: %w", key, err)
+		}
+		ing.Annotations[key] = hosts
+	}
+
+	if err := applyRuleDefaults(ing, r.applied); err != nil {
+		return nil, err
+	}
+
+	return r.changes, nil
+}
+
+// rewrites records the distinct changes made to the hosts of an object and
+// the transforms that were applied.
+type rewrites struct {
+	changes []HostChange
+	applied []Transform
+	seen    map[string]bool
+}
+
+func newRewrites() *rewrites {
+	return &rewrites{seen: make(map[string]bool)}
+}
+
+// transformer returns a function transforming a single host. Hosts of an
+// updated object are left unchanged unless the update policy of the matching
+// rule allows them to be rewritten. Changes that are shadowed, either for
+// every rule or by the matching rule, are recorded but the host is returned
+// unchanged.
+func (r *rewrites) transformer(ctx context.Context, t Transformer, namespace string, o ingressOptions) func(string) (string, error) {
+	return func(host string) (string, error) {
+		res, err := t.Transform(ctx, TransformRequest{
+			Namespace: namespace,
+			Class:     o.Class,
+			Host:      host,
+			DryRun:    o.DryRun,
+			Previous:  o.Previous,
+		})
+		if err != nil {
+			return host, fmt.Errorf("%v: %w", host, err)
+		}
+
+		if res.Host == host {
+			return host, nil
+		}
+
+		c := HostChange{
+			Rule:   res.Rule,
+			From:   host,
+			To:     res.Host,
+			Shadow: o.Shadow || res.Transform.Shadow,
+		}
+
+		if !r.seen[host] {
+			r.seen[host] = true
+			r.changes = append(r.changes, c)
+		}
+
+		if c.Shadow {
+			return host, nil
+		}
+
+		r.applied = append(r.applied, res.Transform)
+
+		return res.Host, nil
+	}
+}
+
+// transformList transforms each host of a comma separated list keeping the
+// surrounding whitespace of every entry.
+func transformList(v string, transform func(string) (string, error)) (string, error) {
+	parts := strings.Split(v, ",")
+
+	for idx, part := range parts {
+		host := strings.TrimSpace(part)
+		if host == "" {
+			continue
+		}
+
+		to, err := transform(host)
+		if err != nil {
+			return v, err
+		}
+
+		lead := part[:strings.Index(part, host)]
+		trail := part[len(lead)+len(host):]
+		parts[idx] = lead + to + trail
+	}
+
+	return strings.Join(parts, ","), nil
+}
+
+// applyRuleDefaults sets the ingress class and default annotations of the
+// rules that rewrote a host. Annotations already present are kept unless the
+// rule overrides them and earlier rules take precedence. The legacy ingress
+// class annotation is removed when the class is set as the API server rejects
+// an Ingress with both. Rules that disagree on the ingress class are rejected
+// rather than choosing a controller arbitrarily.
+func applyRuleDefaults(ing *networkingv1.Ingress, tt []Transform) error {
+	var class string
+	for _, t := range tt {
+		if t.IngressClassName == "" {
+			continue
+		}
+		if class != "" && class != t.IngressClassName {
+			return fmt.Errorf("%w: %v, %v", ErrIngressClassConflict, class, t.IngressClassName)
+		}
+		class = t.IngressClassName
+	}
+
+	if class != "" {
+		ing.Spec.IngressClassName = &class
+		delete(ing.Annotations, legacyClassAnnotation)
+	}
+
+	set := make(map[string]bool)
+	for _, t := range tt {
+		for k, v := range t.Annotations {
+			if set[k] {
+				continue
+			}
+			set[k] = true
+
+			if ing.Annotations == nil {
+				ing.Annotations = make(map[string]string)
+			}
+			if _, ok := ing.Annotations[k]; ok && !t.OverrideAnnotations {
+				continue
+			}
+			ing.Annotations[k] = v
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	aliasAnnotation       = "nginx.ingress.kubernetes.io/server-alias"
+	externalDNSAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+)
+
+func TestTransformIngressFields(t *testing.T) {
+	rules := `
+- name: example
+  from: [example.org]
+  to: example.net
+  ingressClassName: external
+  annotations:
+    cert-manager.io/cluster-issuer: external
+    kubernetes.io/tls-acme: "true"
+- name: internal
+  from: [internal.org]
+  to: internal.net
+  ingressClassName: internal
+- name: override
+  from: [override.org]
+  to: override.net
+  annotations:
+    cert-manager.io/cluster-issuer: override
+  overrideAnnotations: true
+- name: shadow
+  from: [shadow.org]
+  to: shadow.net
+  ingressClassName: shadow
+  shadow: true
+`
+
+	class := func(s string) *string { return &s }
+
+	tests := map[string]struct {
+		hosts       []string
+		annotations map[string]string
+		want        *networkingv1.Ingress
+		wantErr     error
+	}{
+		"annotations": {
+			hosts: []string{"a.example.com"},
+			annotations: map[string]string{
+				aliasAnnotation:       "b.example.org, c.example.com ,d.example.org",
+				externalDNSAnnotation: "a.example.org",
+				"other":               "a.example.org",
+			},
+			want: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					aliasAnnotation:                  "b.example.net, c.example.com ,d.example.net",
+					externalDNSAnnotation:            "a.example.net",
+					"other":                          "a.example.org",
+					"cert-manager.io/cluster-issuer": "external",
+					"kubernetes.io/tls-acme":         "true",
+				}},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: class("external"),
+					Rules:            []networkingv1.IngressRule{{Host: "a.example.com"}},
+				},
+			},
+		},
+		"class and default annotations": {
+			hosts: []string{"a.example.org"},
+			annotations: map[string]string{
+				"cert-manager.io/cluster-issuer": "internal",
+			},
+			want: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"cert-manager.io/cluster-issuer": "internal",
+					"kubernetes.io/tls-acme":         "true",
+				}},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: class("external"),
+					Rules:            []networkingv1.IngressRule{{Host: "a.example.net"}},
+				},
+			},
+		},
+		"legacy class annotation": {
+			hosts: []string{"a.example.org"},
+			annotations: map[string]string{
+				legacyClassAnnotation: "internal",
+			},
+			want: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"cert-manager.io/cluster-issuer": "external",
+					"kubernetes.io/tls-acme":         "true",
+				}},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: class("external"),
+					Rules:            []networkingv1.IngressRule{{Host: "a.example.net"}},
+				},
+			},
+		},
+		"override annotations": {
+			hosts: []string{"a.override.org"},
+			annotations: map[string]string{
+				"cert-manager.io/cluster-issuer": "internal",
+			},
+			want: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"cert-manager.io/cluster-issuer": "override",
+				}},
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "a.override.net"}},
+				},
+			},
+		},
+		"unmatched": {
+			hosts: []string{"a.example.com"},
+			want: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "a.example.com"}},
+				},
+			},
+		},
+		"shadowed": {
+			hosts: []string{"a.shadow.org"},
+			want: &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "a.shadow.org"}},
+				},
+			},
+		},
+		"class conflict": {
+			hosts:   []string{"a.example.org", "a.internal.org"},
+			wantErr: ErrIngressClassConflict,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestTransforms(t, newTestConfigMap(rules))
+
+			ing := &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+			}
+			for _, h := range tc.hosts {
+				ing.Spec.Rules = append(ing.Spec.Rules, networkingv1.IngressRule{Host: h})
+			}
+
+			_, err := transformIngress(context.Background(), ts, testNamespace, ing, ingressOptions{
+				Annotations: []string{aliasAnnotation, externalDNSAnnotation},
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("transformIngress() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tc.want, ing); diff != "" {
+				t.Errorf("transformIngress() mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
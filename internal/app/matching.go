@@ -0,0 +1,150 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mikelorant/muting2/internal/suffix"
+)
+
+const (
+	// MatchingLabel matches suffixes on whole trailing DNS labels.
+	MatchingLabel = "label"
+	// MatchingLegacy matches suffixes as substrings with the leading dot
+	// treated as a wildcard, as earlier releases did.
+	MatchingLegacy = "legacy"
+)
+
+var ErrMatchingUnknown = errors.New("unknown matching mode")
+
+type ruleRef struct {
+	index  int
+	legacy *regexp.Regexp
+}
+
+type legacyRule struct {
+	suffix   string
+	priority int
+	ref      ruleRef
+}
+
+func validateMatching(mode string) error {
+	switch mode {
+	case "", MatchingLabel, MatchingLegacy:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %v", ErrMatchingUnknown, mode)
+}
+
+// matching returns the effective matching mode of a rule.
+func (t Transform) matching(fallback string) string {
+	if t.Matching != "" {
+		return t.Matching
+	}
+
+	if fallback != "" {
+		return fallback
+	}
+
+	return MatchingLabel
+}
+
+// match returns the rules matching the host from both the suffix trie and the
+// legacy rules ordered by precedence.
+func (rs *Rules) match(host string) []suffix.Match[ruleRef] {
+	ms := rs.Trie.Match(host)
+	if len(rs.legacy) == 0 {
+		return ms
+	}
+
+	for _, l := range rs.legacy {
+		if !strings.HasSuffix(host, l.suffix) {
+			continue
+		}
+
+		ms = append(ms, suffix.Match[ruleRef]{
+			Suffix:   l.suffix,
+			Priority: l.priority,
+			Value:    l.ref,
+		})
+	}
+
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Priority < ms[j].Priority
+	})
+
+	return ms
+}
+
+func (rs *Rules) rewrite(m suffix.Match[ruleRef], host string) string {
+	t := rs.Transforms[m.Value.index]
+
+	if m.Value.legacy != nil {
+		return m.Value.legacy.ReplaceAllString(host, fmt.Sprintf("$1.%v", t.To))
+	}
+
+	if m.Prefix == "" {
+		return t.To
+	}
+
+	return fmt.Sprintf("%v.%v", m.Prefix, t.To)
+}
+
+// migrationWarnings reports label matched suffixes that end part way through
+// a label of another suffix. In legacy mode the shorter suffix also matched
+// hosts under the longer one, so those hosts may now be rewritten by a
+// different rule or not at all. It also reports once that hosts equal to a
+// label matched suffix are now rewritten where legacy mode required a leading
+// label, counting only rules that inherit the matching mode as setting it
+// acknowledges the change.
+func migrationWarnings(tt []Transform, mode string) []string {
+	owners := make(map[string]int)
+	for idx, t := range tt {
+		for _, from := range t.From {
+			if _, ok := owners[from]; !ok {
+				owners[from] = idx
+			}
+		}
+	}
+
+	var inherited int
+	for _, t := range tt {
+		if t.Matching == "" && t.matching(mode) == MatchingLabel {
+			inherited++
+		}
+	}
+
+	var warnings []string
+	if inherited > 0 {
+		warnings = append(warnings, fmt.Sprintf(
+			"%v rules without matching now rewrite hosts equal to their suffixes",
+			inherited,
+		))
+	}
+
+	for idx, t := range tt {
+		for _, from := range t.From {
+			for i := 1; i < len(from); i++ {
+				if from[i-1] == '.' {
+					continue
+				}
+
+				owner, ok := owners[from[i:]]
+				if !ok || tt[owner].matching(mode) != MatchingLabel {
+					continue
+				}
+
+				warnings = append(warnings, fmt.Sprintf(
+					"rule %v suffix %v no longer matches hosts under %v of rule %v",
+					ruleLabel(owner, tt[owner]), from[i:], from, ruleLabel(idx, t),
+				))
+			}
+		}
+	}
+
+	return warnings
+}
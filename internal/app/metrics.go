@@ -0,0 +1,162 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	metricsNamespace = "muting"
+
+	outcomeMatched   = "matched"
+	outcomeUnchanged = "unchanged"
+	outcomeError     = "error"
+	outcomeSkipped   = "skipped"
+	outcomeDenied    = "denied"
+)
+
+type TransformMetrics struct {
+	Transforms   *prometheus.CounterVec
+	RuleMatches  *prometheus.CounterVec
+	LoadDuration prometheus.Histogram
+	Rules        prometheus.Gauge
+	ReadFailures prometheus.Counter
+
+	mu       sync.RWMutex
+	modified time.Time
+}
+
+func newTransformMetrics(r prometheus.Registerer) (*TransformMetrics, error) {
+	if r == nil {
+		r = prometheus.NewRegistry()
+	}
+
+	m := &TransformMetrics{
+		Transforms: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "transform_total",
+			Help:      "Number of host transforms by rule, namespace and outcome.",
+		}, []string{"rule", "namespace", "outcome"}),
+		RuleMatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rule_matches_total",
+			Help:      "Number of hosts matched by rule, initialized to zero when the rule is loaded.",
+		}, []string{"rule"}),
+		LoadDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "transforms_load_duration_seconds",
+			Help:      "Time taken to load the transform rules.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		Rules: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "transforms_loaded",
+			Help:      "Number of transform rules currently loaded.",
+		}),
+		ReadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "transforms_configmap_read_failures_total",
+			Help:      "Number of failed reads of the transforms config map.",
+		}),
+	}
+
+	age := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "transforms_configmap_age_seconds",
+		Help:      "Time since the loaded resource version of the transforms config map was written.",
+	}, m.age)
+
+	for _, c := range []prometheus.Collector{m.Transforms, m.RuleMatches, m.LoadDuration, m.Rules, m.ReadFailures, age} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("unable to register metric: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+type WebhookMetrics struct {
+	Shadowed *prometheus.CounterVec
+	Skipped  *prometheus.CounterVec
+}
+
+func newWebhookMetrics(r prometheus.Registerer) (*WebhookMetrics, error) {
+	if r == nil {
+		r = prometheus.NewRegistry()
+	}
+
+	m := &WebhookMetrics{
+		Shadowed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "hosts_shadowed_total",
+			Help:      "Number of host rewrites computed but not applied in shadow mode by rule and namespace.",
+		}, []string{"rule", "namespace"}),
+		Skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ingresses_skipped_total",
+			Help:      "Number of Ingresses not reviewed because of their ingress class by namespace and class.",
+		}, []string{"namespace", "class"}),
+	}
+
+	for _, c := range []prometheus.Collector{m.Shadowed, m.Skipped} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("unable to register metric: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *TransformMetrics) observe(rule, namespace, outcome string) {
+	m.Transforms.WithLabelValues(rule, namespace, outcome).Inc()
+
+	if outcome == outcomeMatched {
+		m.RuleMatches.WithLabelValues(rule).Inc()
+	}
+}
+
+// initRules creates the match series of every loaded rule so that a rule that
+// never matches is reported as zero rather than missing.
+func (m *TransformMetrics) initRules(tt []Transform) {
+	for idx, t := range tt {
+		m.RuleMatches.WithLabelValues(ruleLabel(idx, t))
+	}
+}
+
+func (m *TransformMetrics) setModified(obj metav1.Object) {
+	t := obj.GetCreationTimestamp().Time
+	for _, f := range obj.GetManagedFields() {
+		if f.Time != nil && f.Time.After(t) {
+			t = f.Time.Time
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.modified = t
+}
+
+func (m *TransformMetrics) age() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.modified.IsZero() {
+		return 0
+	}
+
+	return time.Since(m.modified).Seconds()
+}
+
+func ruleLabel(idx int, t Transform) string {
+	if t.Name != "" {
+		return t.Name
+	}
+
+	return strconv.Itoa(idx)
+}
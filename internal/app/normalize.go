@@ -0,0 +1,90 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// EncodingPunycode writes rewritten hosts as ASCII with internationalized
+	// labels in punycode, the form required by the Ingress API.
+	EncodingPunycode = "punycode"
+	// EncodingUnicode writes rewritten hosts with internationalized labels in
+	// Unicode for controllers that expect them.
+	EncodingUnicode = "unicode"
+)
+
+var ErrEncodingUnknown = errors.New("unknown host encoding")
+
+func validateEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingPunycode, EncodingUnicode:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %v", ErrEncodingUnknown, encoding)
+}
+
+// normalizeHost returns the canonical form of a host used for matching. The
+// host is lowercased, a trailing dot is removed and internationalized labels
+// are converted to punycode. A leading wildcard label is kept as is.
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+
+	prefix := ""
+	if isWildcard(host) {
+		prefix, host = "*.", strings.TrimPrefix(host, "*.")
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %v", ErrInvalidHost, host, err)
+	}
+
+	return prefix + ascii, nil
+}
+
+// encodeHost converts a normalized host to the output encoding.
+func encodeHost(host, encoding string) (string, error) {
+	if encoding != EncodingUnicode {
+		return host, nil
+	}
+
+	prefix := ""
+	if isWildcard(host) {
+		prefix, host = "*.", strings.TrimPrefix(host, "*.")
+	}
+
+	unicode, err := idna.Lookup.ToUnicode(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %v", ErrInvalidHost, host, err)
+	}
+
+	return prefix + unicode, nil
+}
+
+// normalize returns the rule with its suffixes and target in canonical form
+// so rules match regardless of how they were written.
+func (t Transform) normalize() (Transform, error) {
+	from := make([]string, len(t.From))
+	for idx, f := range t.From {
+		n, err := normalizeHost(f)
+		if err != nil {
+			return t, fmt.Errorf("invalid from: %w", err)
+		}
+		from[idx] = n
+	}
+
+	to, err := normalizeHost(t.To)
+	if err != nil {
+		return t, fmt.Errorf("invalid to: %w", err)
+	}
+
+	t.From = from
+	t.To = to
+
+	return t, nil
+}
@@ -10,6 +10,7 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
@@ -17,6 +18,7 @@ import (
 
 type Observability struct {
 	TracerProvider *trace.TracerProvider
+	Profiler       *pyroscope.Profiler
 	Registry       *prometheus.Registry
 	Options        ObservabilityOptions
 }
@@ -43,12 +45,38 @@ func newObservability(ctx context.Context, o ObservabilityOptions) (Observabilit
 	obs.TracerProvider = tp
 
 	if o.Debug {
-		obs.startProfiler()
+		p, err := obs.startProfiler()
+		if err != nil {
+			return obs, fmt.Errorf("unable to start profiler: %w", err)
+		}
+		obs.Profiler = p
 	}
 
 	return obs, nil
 }
 
+func (o *Observability) Shutdown(ctx context.Context) error {
+	if o.Profiler != nil {
+		if err := o.Profiler.Stop(); err != nil {
+			return fmt.Errorf("unable to stop profiler: %w", err)
+		}
+	}
+
+	if o.TracerProvider == nil {
+		return nil
+	}
+
+	if err := o.TracerProvider.ForceFlush(ctx); err != nil {
+		return fmt.Errorf("unable to flush tracer provider: %w", err)
+	}
+
+	if err := o.TracerProvider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("unable to shutdown tracer provider: %w", err)
+	}
+
+	return nil
+}
+
 func (o *Observability) getTracerProvider(ctx context.Context) (*trace.TracerProvider, error) {
 	cl := otlptracehttp.NewClient(otlptracehttp.WithInsecure())
 	exp, err := otlptrace.New(ctx, cl)
@@ -71,13 +99,17 @@ func (o *Observability) getTracerProvider(ctx context.Context) (*trace.TracerPro
 		trace.WithResource(res),
 	)
 	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
 	otel.SetErrorHandler(noopErrorHandler())
 
 	return tp, nil
 }
 
-func (o *Observability) startProfiler() {
-	pyroscope.Start(pyroscope.Config{
+func (o *Observability) startProfiler() (*pyroscope.Profiler, error) {
+	return pyroscope.Start(pyroscope.Config{
 		ApplicationName: o.Options.ProfilerName,
 		ServerAddress:   o.Options.ProfilerAddr,
 		ProfileTypes: []pyroscope.ProfileType{
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	apicorev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// NamespacePolicy restricts the namespaces in which a rule may produce its
+// rewritten suffix.
+type NamespacePolicy struct {
+	Selector    map[string]string `yaml:"namespaceSelector,omitempty"`
+	Namespaces  []string          `yaml:"namespaces,omitempty"`
+	Allowed     []string          `yaml:"allowedNamespaces,omitempty"`
+	OnViolation string            `yaml:"onViolation,omitempty"`
+}
+
+const (
+	ViolationSkip = "skip"
+	ViolationDeny = "deny"
+)
+
+var (
+	ErrNamespaceNotAllowed = errors.New("namespace not allowed")
+	ErrViolationUnknown    = errors.New("unknown violation action")
+)
+
+// namespaceLabels lazily looks up the labels of a namespace so that rules
+// without a selector do not require a lookup. Namespaces are read from the
+// lister once the transformer is started and from the API server otherwise.
+type namespaceLabels struct {
+	client    corev1.NamespacesGetter
+	lister    corelisters.NamespaceLister
+	namespace string
+	labels    labels.Set
+	err       error
+	done      bool
+}
+
+func (p NamespacePolicy) validate() error {
+	switch p.OnViolation {
+	case "", ViolationSkip, ViolationDeny:
+	default:
+		return fmt.Errorf("%w: %v", ErrViolationUnknown, p.OnViolation)
+	}
+
+	for _, pattern := range p.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern: %v: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func (p NamespacePolicy) allows(ctx context.Context, nl *namespaceLabels) (bool, error) {
+	if !matchesAny(p.Namespaces, nl.namespace) {
+		return false, nil
+	}
+
+	if len(p.Allowed) != 0 && !contains(p.Allowed, nl.namespace) {
+		return false, nil
+	}
+
+	if len(p.Selector) == 0 {
+		return true, nil
+	}
+
+	ls, err := nl.get(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to get namespace labels: %w", err)
+	}
+
+	return labels.SelectorFromSet(p.Selector).Matches(ls), nil
+}
+
+func (p NamespacePolicy) deny() bool {
+	return p.OnViolation == ViolationDeny
+}
+
+// onViolation returns the effective action for a namespace outside the
+// policy.
+func (p NamespacePolicy) onViolation() string {
+	if p.OnViolation == "" {
+		return ViolationSkip
+	}
+
+	return p.OnViolation
+}
+
+func (nl *namespaceLabels) get(ctx context.Context) (labels.Set, error) {
+	if nl.done {
+		return nl.labels, nl.err
+	}
+
+	ctx, span := otel.Tracer(name).Start(ctx, "getNamespaceLabels")
+	defer span.End()
+
+	nl.done = true
+
+	ns, err := getNamespace(ctx, nl.lister, nl.client, nl.namespace)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		nl.err = err
+		return nil, err
+	}
+
+	nl.labels = ns.Labels
+
+	return nl.labels, nil
+}
+
+// getNamespace reads a namespace from the lister when set and from the API
+// server otherwise. A namespace missing from the lister is read from the API
+// server as it may have been created moments before its objects.
+func getNamespace(ctx context.Context, lister corelisters.NamespaceLister, client corev1.NamespacesGetter, name string) (*apicorev1.Namespace, error) {
+	if lister != nil {
+		ns, err := lister.Get(name)
+		if !apierrors.IsNotFound(err) {
+			return ns, err
+		}
+	}
+
+	return client.Namespaces().Get(ctx, name, metav1.GetOptions{})
+}
+
+// matchesAny reports whether the namespace matches one of the glob patterns.
+// An empty list matches every namespace.
+func matchesAny(patterns []string, namespace string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNamespacePolicy(t *testing.T) {
+	objs := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "team-a",
+			Labels: map[string]string{"tier": "public"},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "team-b",
+			Labels: map[string]string{"tier": "private"},
+		}},
+	}
+
+	tests := map[string]struct {
+		policy    string
+		namespace string
+		want      string
+		wantErr   bool
+		errIs     error
+	}{
+		"selector match": {
+			policy:    "namespaceSelector: {tier: public}",
+			namespace: "team-a",
+			want:      "a.example.net",
+		},
+		"selector mismatch": {
+			policy:    "namespaceSelector: {tier: public}",
+			namespace: "team-b",
+			want:      "a.example.fallback.net",
+		},
+		"glob match": {
+			policy:    "namespaces: [team-*]",
+			namespace: "team-b",
+			want:      "a.example.net",
+		},
+		"glob mismatch": {
+			policy:    "namespaces: [team-*]",
+			namespace: "default",
+			want:      "a.example.fallback.net",
+		},
+		"allowed": {
+			policy:    "allowedNamespaces: [team-a]",
+			namespace: "team-a",
+			want:      "a.example.net",
+		},
+		"not allowed": {
+			policy:    "allowedNamespaces: [team-a]",
+			namespace: "team-b",
+			want:      "a.example.fallback.net",
+		},
+		"skip": {
+			policy:    "allowedNamespaces: [team-a]\n  onViolation: skip",
+			namespace: "team-b",
+			want:      "a.example.fallback.net",
+		},
+		"deny": {
+			policy:    "allowedNamespaces: [team-a]\n  onViolation: deny",
+			namespace: "team-b",
+			wantErr:   true,
+			errIs:     ErrNamespaceNotAllowed,
+		},
+		"lookup failure": {
+			policy:    "namespaceSelector: {tier: public}",
+			namespace: "missing",
+			wantErr:   true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rules := "- from: [example.org]\n  to: example.net\n  " + tc.policy +
+				"\n- from: [org]\n  to: fallback.net\n"
+
+			ts := newTestTransforms(t, append(objs, newTestConfigMap(rules))...)
+			if err := ts.Start(ctx); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			got, err := ts.Transform(ctx, TransformRequest{Namespace: tc.namespace, Host: "a.example.org"})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Transform() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.errIs != nil && !errors.Is(err, tc.errIs) {
+				t.Fatalf("Transform() error = %v, want %v", err, tc.errIs)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			if got.Host != tc.want {
+				t.Errorf("Transform() host = %v, want %v", got.Host, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetNamespace(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+
+	// The informer has not seen the namespace created with its objects yet.
+	lister := corelisters.NewNamespaceLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+
+	got, err := getNamespace(context.Background(), lister, fake.NewSimpleClientset(ns).CoreV1(), "team-a")
+	if err != nil {
+		t.Fatalf("getNamespace() error = %v", err)
+	}
+	if got.Name != "team-a" {
+		t.Errorf("getNamespace() = %v, want team-a", got.Name)
+	}
+
+	if _, err := getNamespace(context.Background(), lister, fake.NewSimpleClientset().CoreV1(), "team-a"); !apierrors.IsNotFound(err) {
+		t.Errorf("getNamespace() error = %v, want not found", err)
+	}
+}
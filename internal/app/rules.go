@@ -0,0 +1,267 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+)
+
+const rulesTimeout = 10 * time.Second
+
+// RuleStatus describes an effective rule, where it was loaded from and how
+// many hosts it has rewritten since the process started.
+type RuleStatus struct {
+	Name                string            `json:"name"`
+	From                []string          `json:"from"`
+	To                  string            `json:"to"`
+	Matching            string            `json:"matching"`
+	Wildcards           string            `json:"wildcards"`
+	Shadow              bool              `json:"shadow"`
+	Update              string            `json:"update"`
+	IngressClasses      []string          `json:"ingressClasses,omitempty"`
+	IngressClassName    string            `json:"ingressClassName,omitempty"`
+	Annotations         map[string]string `json:"annotations,omitempty"`
+	OverrideAnnotations bool              `json:"overrideAnnotations,omitempty"`
+	NamespaceSelector   map[string]string `json:"namespaceSelector,omitempty"`
+	Namespaces          []string          `json:"namespaces,omitempty"`
+	AllowedNamespaces   []string          `json:"allowedNamespaces,omitempty"`
+	OnViolation         string            `json:"onViolation"`
+	Scope               string            `json:"scope,omitempty"`
+	Source              string            `json:"source"`
+	Loaded              time.Time         `json:"loaded"`
+	Hits                uint64            `json:"hits"`
+}
+
+type RuleStatuses []RuleStatus
+
+// RulesOptions selects where ListRules reads the rules from. The ops endpoint
+// of a running instance is queried when set, otherwise the config map is read
+// directly.
+type RulesOptions struct {
+	Endpoint  string
+	Namespace string
+	Name      string
+	Config    *rest.Config
+}
+
+// ListRules returns the effective rules of a running instance or of the
+// config map.
+func ListRules(ctx context.Context, o RulesOptions) (RuleStatuses, error) {
+	if o.Endpoint != "" {
+		return getRules(ctx, o.Endpoint)
+	}
+
+	cl, err := newClient(ctx, o.Config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get new client: %w", err)
+	}
+
+	ts, err := newTransformer(TransformOptions{
+		Namespace: o.Namespace,
+		Name:      o.Name,
+		Client:    cl.CoreV1(),
+		Log:       logr.Discard(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create transformer: %w", err)
+	}
+
+	if _, err := ts.read(ctx); err != nil {
+		return nil, fmt.Errorf("unable to read transforms: %w", err)
+	}
+
+	return ts.Rules(), nil
+}
+
+func getRules(ctx context.Context, endpoint string) (RuleStatuses, error) {
+	ctx, cancel := context.WithTimeout(ctx, rulesTimeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(endpoint, "/") + "/rules"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get rules: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to get rules: %v: %v", url, resp.Status)
+	}
+
+	var rs RuleStatuses
+	if err := json.NewDecoder(resp.Body).Decode(&rs); err != nil {
+		return nil, fmt.Errorf("unable to decode rules: %w", err)
+	}
+
+	return rs, nil
+}
+
+// Rules returns the currently effective rules in precedence order.
+func (ts *Transforms) Rules() RuleStatuses {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var (
+		rs  RuleStatuses
+		idx int
+	)
+	for _, st := range ts.states {
+		if !st.ok {
+			continue
+		}
+
+		for _, t := range st.transforms {
+			rs = append(rs, RuleStatus{
+				Name:                ruleLabel(idx, t),
+				From:                t.From,
+				To:                  t.To,
+				Matching:            t.matching(ts.Options.Matching),
+				Wildcards:           t.wildcards(),
+				Shadow:              t.Shadow,
+				Update:              t.update(),
+				IngressClasses:      t.IngressClasses,
+				IngressClassName:    t.IngressClassName,
+				Annotations:         t.Annotations,
+				OverrideAnnotations: t.OverrideAnnotations,
+				NamespaceSelector:   t.Selector,
+				Namespaces:          t.Namespaces,
+				AllowedNamespaces:   t.Allowed,
+				OnViolation:         t.onViolation(),
+				Scope:               t.Scope,
+				Source:              t.Source,
+				Loaded:              st.status.Loaded,
+				Hits:                ts.hitCount(t),
+			})
+			idx++
+		}
+	}
+
+	return rs
+}
+
+// RulesHandler serves the effective rules as JSON.
+func (ts *Transforms) RulesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs := ts.Rules()
+		if rs == nil {
+			rs = RuleStatuses{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rs)
+	})
+}
+
+// hit counts a rewrite by a rule. Counts are kept by source and rule so they
+// survive reloads that leave the rule unchanged.
+func (ts *Transforms) hit(t Transform) {
+	v, _ := ts.hits.LoadOrStore(hitKey(t), new(uint64))
+	atomic.AddUint64(v.(*uint64), 1)
+}
+
+func (ts *Transforms) hitCount(t Transform) uint64 {
+	v, ok := ts.hits.Load(hitKey(t))
+	if !ok {
+		return 0
+	}
+
+	return atomic.LoadUint64(v.(*uint64))
+}
+
+func hitKey(t Transform) string {
+	return t.Source + "|" + t.String()
+}
+
+func (rs RuleStatuses) Header() []string {
+	return []string{
+		"name", "from", "to", "matching", "wildcards", "shadow", "update",
+		"classes", "class", "annotations", "namespaces", "selector", "allowed", "violation",
+		"scope", "source", "loaded", "hits",
+	}
+}
+
+func (rs RuleStatuses) Rows() [][]string {
+	rows := make([][]string, len(rs))
+	for idx, r := range rs {
+		loaded := "-"
+		if !r.Loaded.IsZero() {
+			loaded = r.Loaded.Format(time.RFC3339)
+		}
+
+		scope := r.Scope
+		if scope == "" {
+			scope = "*"
+		}
+
+		annotations := joinSet(r.Annotations, "-")
+		if r.OverrideAnnotations && len(r.Annotations) != 0 {
+			annotations += " (override)"
+		}
+
+		rows[idx] = []string{
+			r.Name,
+			strings.Join(r.From, ","),
+			r.To,
+			r.Matching,
+			r.Wildcards,
+			strconv.FormatBool(r.Shadow),
+			r.Update,
+			joinList(r.IngressClasses, "*"),
+			orDefault(r.IngressClassName, "-"),
+			annotations,
+			joinList(r.Namespaces, "*"),
+			joinSet(r.NamespaceSelector, "*"),
+			joinList(r.AllowedNamespaces, "*"),
+			r.OnViolation,
+			scope,
+			r.Source,
+			loaded,
+			strconv.FormatUint(r.Hits, 10),
+		}
+	}
+
+	return rows
+}
+
+// joinList formats a list for a table cell, using the default when it is
+// empty.
+func joinList(l []string, def string) string {
+	if len(l) == 0 {
+		return def
+	}
+
+	return strings.Join(l, ",")
+}
+
+// joinSet formats a map as sorted key=value pairs for a table cell, using the
+// default when it is empty.
+func joinSet(m map[string]string, def string) string {
+	if len(m) == 0 {
+		return def
+	}
+
+	return labels.Set(m).String()
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
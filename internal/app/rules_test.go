@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestTransformsRules(t *testing.T) {
+	ts := newTestTransforms(t, newTestConfigMap(`
+- name: example
+  from: [example.org]
+  to: example.net
+- from: [example.com]
+  to: example.net
+  wildcards: ignore
+- name: policy
+  from: [example.io]
+  to: example.net
+  ingressClasses: [nginx]
+  ingressClassName: internal
+  annotations: {team: platform}
+  namespaceSelector: {muting: enabled}
+  namespaces: [team-*]
+  allowedNamespaces: [team-a]
+  onViolation: deny
+`))
+
+	for _, host := range []string{"a.example.org", "b.example.org", "c.example.io"} {
+		if _, err := ts.Transform(context.Background(), TransformRequest{Namespace: testNamespace, Host: host}); err != nil {
+			t.Fatalf("Transform() error = %v", err)
+		}
+	}
+
+	want := RuleStatuses{
+		{
+			Name:        "example",
+			From:        []string{"example.org"},
+			To:          "example.net",
+			Matching:    MatchingLabel,
+			Wildcards:   WildcardRewrite,
+			Update:      UpdateOnChange,
+			OnViolation: ViolationSkip,
+			Source:      "configmap:muting/muting",
+			Hits:        2,
+		},
+		{
+			Name:        "1",
+			From:        []string{"example.com"},
+			To:          "example.net",
+			Matching:    MatchingLabel,
+			Wildcards:   WildcardIgnore,
+			Update:      UpdateOnChange,
+			OnViolation: ViolationSkip,
+			Source:      "configmap:muting/muting",
+		},
+		{
+			Name:              "policy",
+			From:              []string{"example.io"},
+			To:                "example.net",
+			Matching:          MatchingLabel,
+			Wildcards:         WildcardRewrite,
+			Update:            UpdateOnChange,
+			IngressClasses:    []string{"nginx"},
+			IngressClassName:  "internal",
+			Annotations:       map[string]string{"team": "platform"},
+			NamespaceSelector: map[string]string{"muting": "enabled"},
+			Namespaces:        []string{"team-*"},
+			AllowedNamespaces: []string{"team-a"},
+			OnViolation:       ViolationDeny,
+			Source:            "configmap:muting/muting",
+		},
+	}
+
+	got := ts.Rules()
+	for _, r := range got {
+		if r.Loaded.IsZero() {
+			t.Errorf("Rules() %v loaded time is zero", r.Name)
+		}
+	}
+
+	opts := cmpopts.IgnoreFields(RuleStatus{}, "Loaded")
+	if diff := cmp.Diff(want, got, opts); diff != "" {
+		t.Errorf("Rules() mismatch (-want +got):\n%v", diff)
+	}
+
+	srv := httptest.NewServer(ts.RulesHandler())
+	defer srv.Close()
+
+	listed, err := ListRules(context.Background(), RulesOptions{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("ListRules() error = %v", err)
+	}
+
+	if diff := cmp.Diff(got, listed, cmpopts.EquateApproxTime(0)); diff != "" {
+		t.Errorf("ListRules() mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestRuleStatusesRows(t *testing.T) {
+	rs := RuleStatuses{
+		{
+			Name:        "example",
+			From:        []string{"example.org"},
+			To:          "example.net",
+			Matching:    MatchingLabel,
+			Wildcards:   WildcardRewrite,
+			Update:      UpdateOnChange,
+			OnViolation: ViolationSkip,
+			Source:      "configmap:muting/muting",
+		},
+		{
+			Name:                "policy",
+			From:                []string{"example.io", "example.com"},
+			To:                  "example.net",
+			Matching:            MatchingLabel,
+			Wildcards:           WildcardRewrite,
+			Update:              UpdateOnChange,
+			IngressClasses:      []string{"nginx", "traefik"},
+			IngressClassName:    "internal",
+			Annotations:         map[string]string{"team": "platform", "tier": "web"},
+			OverrideAnnotations: true,
+			NamespaceSelector:   map[string]string{"muting": "enabled"},
+			Namespaces:          []string{"team-*"},
+			AllowedNamespaces:   []string{"team-a"},
+			OnViolation:         ViolationDeny,
+			Scope:               "team-a",
+			Source:              "namespaces:team-a/muting",
+			Hits:                3,
+		},
+	}
+
+	want := [][]string{
+		{"example", "example.org", "example.net", "label", "rewrite", "false", "on-change", "*", "-", "-", "*", "*", "*", "skip", "*", "configmap:muting/muting", "-", "0"},
+		{"policy", "example.io,example.com", "example.net", "label", "rewrite", "false", "on-change", "nginx,traefik", "internal", "team=platform,tier=web (override)", "team-*", "muting=enabled", "team-a", "deny", "team-a", "namespaces:team-a/muting", "-", "3"},
+	}
+
+	got := rs.Rows()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Rows() mismatch (-want +got):\n%v", diff)
+	}
+
+	for _, row := range got {
+		if len(row) != len(rs.Header()) {
+			t.Errorf("Rows() columns = %v, want %v", len(row), len(rs.Header()))
+		}
+	}
+}
@@ -3,8 +3,10 @@ package app
 import (
 	"context"
 	cryptotls "crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-logr/logr"
 	"github.com/mikelorant/muting2/internal/tls"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -20,8 +23,13 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+const validatePath = "/validate"
+
+var ErrShuttingDown = errors.New("shutting down")
+
 type Handler interface {
 	Handler() http.Handler
+	ValidatingHandler() http.Handler
 }
 
 type Metrics interface {
@@ -30,27 +38,54 @@ type Metrics interface {
 }
 
 type Server struct {
-	Options ServerOptions
+	Options  ServerOptions
+	Draining *HealthGate
 }
 
 type ServerOptions struct {
 	Keypair *tls.Keypair
+	CA      []byte
+	Health  *Health
 	Addr    string
+	Drain   time.Duration
+	Timeout time.Duration
 	Webhook Handler
-	Metrics Metrics
+	Capture *Capture
+	Log     logr.Logger
+}
+
+// OpsServer serves metrics, health and debug endpoints. It is started before
+// the webhook so that readiness is reported while the webhook starts.
+type OpsServer struct {
+	Options OpsServerOptions
+
+	server *http.Server
+	errc   chan error
+}
+
+type OpsServerOptions struct {
+	Addr      string
+	Profiling bool
+	Sources   http.Handler
+	Rules     http.Handler
+	Metrics   Metrics
+	Health    *Health
+	Log       logr.Logger
 }
 
 func newServer(ctx context.Context, o ServerOptions) error {
 	s := Server{
-		Options: o,
+		Options:  o,
+		Draining: newHealthGate(nil),
 	}
 
-	s.startWithTLSKeypair(ctx,
+	s.Options.Health.AddLivenessCheck("webhook", s.checkListener)
+	s.Options.Health.AddReadinessCheck("shutdown", s.Draining.Check)
+
+	return s.startWithTLSKeypair(ctx,
 		s.Options.Keypair.GetCertificate(),
 		s.Options.Keypair.GetKey(),
 	)
-
-	return nil
 }
 
 func (s *Server) startWithTLSKeypair(ctx context.Context, cert, key []byte) error {
@@ -63,27 +98,50 @@ func (s *Server) startWithTLSKeypair(ctx context.Context, cert, key []byte) erro
 	srv := &http.Server{
 		Addr:         s.Options.Addr,
 		TLSConfig:    tlscfg,
-		Handler:      getRouter(ctx, s.Options.Webhook, s.Options.Metrics),
+		Handler:      getRouter(ctx, s.Options.Webhook, s.Options.Capture, s.Options.Log),
 		ReadTimeout:  time.Minute,
 		WriteTimeout: time.Minute,
 	}
 
+	// The listener is bound before serving so that an address in use fails
+	// startup instead of a server that never becomes live.
+	ln, err := net.Listen("tcp", s.Options.Addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen: %w", err)
+	}
+
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(done)
 
-	g := new(errgroup.Group)
+	g, gctx := errgroup.WithContext(ctx)
+	served := make(chan struct{})
 
 	g.Go(func() error {
-		if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			return fmt.Errorf("unable to listen and serve: %w", err)
+		defer close(served)
+
+		if err := srv.ServeTLS(ln, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("unable to serve: %w", err)
 		}
 
 		return nil
 	})
 
-	<-done
+	select {
+	case sig := <-done:
+		s.Options.Log.Info("Received signal, draining.", "signal", sig, "drain", s.Options.Drain)
+		s.drain(served)
+	case <-gctx.Done():
+		if ctx.Err() != nil {
+			s.Options.Log.Info("Context done, draining.", "drain", s.Options.Drain)
+			s.drain(served)
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), s.Options.Timeout)
+	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := srv.Shutdown(sctx); err != nil {
 		return fmt.Errorf("unable to shutdown server: %w", err)
 	}
 
@@ -94,18 +152,143 @@ func (s *Server) startWithTLSKeypair(ctx context.Context, cert, key []byte) erro
 	return nil
 }
 
-func getRouter(ctx context.Context, h Handler, m Metrics) *chi.Mux {
+// newOpsServer binds the ops listener and serves it in the background until
+// it is shut down.
+func newOpsServer(o OpsServerOptions) (*OpsServer, error) {
+	ln, err := net.Listen("tcp", o.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen: %w", err)
+	}
+
+	s := &OpsServer{
+		Options: o,
+		server: &http.Server{
+			Addr:         o.Addr,
+			Handler:      getOpsRouter(o.Metrics, o.Health, o.Sources, o.Rules, o.Profiling, o.Log),
+			ReadTimeout:  time.Minute,
+			WriteTimeout: time.Minute,
+		},
+		errc: make(chan error, 1),
+	}
+
+	go func() {
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.errc <- fmt.Errorf("unable to serve ops: %w", err)
+		}
+		close(s.errc)
+	}()
+
+	return s, nil
+}
+
+// Shutdown stops the ops server and returns the error that stopped it
+// serving, if any.
+func (s *OpsServer) Shutdown(ctx context.Context) error {
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("unable to shutdown ops server: %w", err)
+	}
+
+	return <-s.errc
+}
+
+// drain fails readiness while continuing to serve requests so that endpoints
+// are removed before the listeners are closed. It stops early when the server
+// stops serving.
+func (s *Server) drain(served <-chan struct{}) {
+	s.Draining.Set(ErrShuttingDown)
+
+	t := time.NewTimer(s.Options.Drain)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-served:
+	}
+}
+
+// checkListener completes a TLS handshake against the admission listener to
+// detect a server that is no longer accepting connections.
+func (s *Server) checkListener(ctx context.Context) error {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(s.Options.CA)
+
+	d := cryptotls.Dialer{
+		Config: &cryptotls.Config{
+			RootCAs:    pool,
+			ServerName: s.Options.Keypair.Certificate.Subject.CommonName,
+			MinVersion: cryptotls.VersionTLS12,
+		},
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", s.Options.Addr)
+	if err != nil {
+		return fmt.Errorf("unable to connect to listener: %w", err)
+	}
+
+	return conn.Close()
+}
+
+func getRouter(ctx context.Context, h Handler, c *Capture, l logr.Logger) *chi.Mux {
 	wh := h.Handler()
+	vwh := h.ValidatingHandler()
+	if c != nil {
+		wh = c.Middleware(wh)
+		vwh = c.Middleware(vwh)
+	}
+
 	oh := otelhttp.NewHandler(wh, "Handler")
-	ph := promhttp.InstrumentMetricHandler(m, promhttp.HandlerFor(m, promhttp.HandlerOpts{}))
+	vh := otelhttp.NewHandler(vwh, "ValidatingHandler")
 
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(requestLogger(l))
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Heartbeat("/status"))
-	r.Mount("/debug", middleware.Profiler())
 	r.Handle("/", oh)
+	r.Handle(validatePath, vh)
+
+	return r
+}
+
+func getOpsRouter(m Metrics, hl *Health, sources, rules http.Handler, profiling bool, l logr.Logger) *chi.Mux {
+	ph := promhttp.InstrumentMetricHandler(m, promhttp.HandlerFor(m, promhttp.HandlerOpts{}))
+
+	r := chi.NewRouter()
+	r.Use(requestLogger(l))
+	r.Use(middleware.Recoverer)
+	if profiling {
+		r.Mount("/debug", middleware.Profiler())
+	}
 	r.Handle("/metrics", ph)
+	r.Handle("/healthz", hl.LivenessHandler())
+	r.Handle("/livez", hl.LivenessHandler())
+	r.Handle("/readyz", hl.ReadinessHandler())
+	if sources != nil {
+		r.Handle("/debug/sources", sources)
+	}
+	if rules != nil {
+		r.Handle("/rules", rules)
+	}
 
 	return r
 }
+
+func requestLogger(l logr.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				l.V(1).Info("Served request.",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"remote", r.RemoteAddr,
+					"status", ww.Status(),
+					"bytes", ww.BytesWritten(),
+					"duration", time.Since(start),
+				)
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package app
+
+import (
+	"context"
+	cryptotls "crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/mikelorant/muting2/internal/tls"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stubHandler serves empty responses, blocking the admission handler until
+// block is closed when it is set.
+type stubHandler struct {
+	block chan struct{}
+}
+
+func (h stubHandler) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.block != nil {
+			<-h.block
+		}
+	})
+}
+
+func (stubHandler) ValidatingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func newTestServerOptions(t *testing.T, addr string) ServerOptions {
+	t.Helper()
+
+	ts, err := tls.NewTLS(context.Background(), tls.Options{CommonName: "localhost"})
+	if err != nil {
+		t.Fatalf("unable to create keypair: %v", err)
+	}
+
+	return ServerOptions{
+		Addr:    addr,
+		Timeout: time.Second,
+		Webhook: stubHandler{},
+		Keypair: ts.Keypair,
+		CA:      ts.CA.GetCertificate(),
+		Health:  newHealth(HealthOptions{Timeout: time.Second}),
+		Log:     logr.Discard(),
+	}
+}
+
+func TestServerListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- newServer(context.Background(), newTestServerOptions(t, ln.Addr().String()))
+	}()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("newServer() error = nil, want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("newServer() did not return with the address in use")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	addr := freeAddr(t)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	o := newTestServerOptions(t, addr)
+	o.Timeout = 100 * time.Millisecond
+	o.Webhook = stubHandler{block: block}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- newServer(ctx, o)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &cryptotls.Config{InsecureSkipVerify: true}},
+	}
+
+	waitListening(t, addr)
+
+	// The request is held by the handler so that shutdown cannot complete.
+	go func() {
+		if resp, err := client.Post("https://"+addr+"/", "application/json", nil); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("newServer() error = nil, want a shutdown timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("newServer() did not return after the shutdown timeout")
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	addr := freeAddr(t)
+
+	o := newTestServerOptions(t, addr)
+	o.Drain = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- newServer(ctx, o)
+	}()
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		o.Health.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	waitListening(t, addr)
+
+	if got := ready(); got != http.StatusOK {
+		t.Fatalf("readiness before cancel = %v, want %v", got, http.StatusOK)
+	}
+
+	cancel()
+
+	deadline := time.Now().Add(o.Drain / 2)
+	for ready() != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatalf("readiness did not fail while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !listening(addr) {
+		t.Errorf("server stopped listening while draining")
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("newServer() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("newServer() did not return after draining")
+	}
+}
+
+func TestOpsRouter(t *testing.T) {
+	tests := map[string]struct {
+		profiling bool
+		want      map[string]int
+	}{
+		"default": {
+			want: map[string]int{
+				"/metrics":       http.StatusOK,
+				"/healthz":       http.StatusOK,
+				"/livez":         http.StatusOK,
+				"/readyz":        http.StatusOK,
+				"/debug/pprof/":  http.StatusNotFound,
+				"/debug/vars":    http.StatusNotFound,
+				"/debug/sources": http.StatusOK,
+				"/rules":         http.StatusOK,
+				"/validate":      http.StatusNotFound,
+			},
+		},
+		"profiling": {
+			profiling: true,
+			want: map[string]int{
+				"/debug/pprof/":  http.StatusOK,
+				"/debug/vars":    http.StatusOK,
+				"/debug/sources": http.StatusOK,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			r := getOpsRouter(prometheus.NewRegistry(), newHealth(HealthOptions{}), ok, ok, tc.profiling, logr.Discard())
+
+			for path, want := range tc.want {
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+				if rec.Code != want {
+					t.Errorf("GET %v status = %v, want %v", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := getRouter(context.Background(), stubHandler{}, nil, logr.Discard())
+
+	tests := map[string]int{
+		"/":             http.StatusOK,
+		validatePath:    http.StatusOK,
+		"/metrics":      http.StatusNotFound,
+		"/readyz":       http.StatusNotFound,
+		"/rules":        http.StatusNotFound,
+		"/debug/pprof/": http.StatusNotFound,
+	}
+
+	for path, want := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+
+		if rec.Code != want {
+			t.Errorf("POST %v status = %v, want %v", path, rec.Code, want)
+		}
+	}
+}
+
+// freeAddr returns a local address that is not in use.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+func listening(addr string) bool {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !listening(addr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("server is not listening: %v", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// NamespaceEnabled is the value of the namespace label that enables
+	// muting for a namespace.
+	NamespaceEnabled = "enabled"
+	// NamespaceShadow is the value of the namespace label that enables muting
+	// for a namespace in shadow mode.
+	NamespaceShadow = "shadow"
+
+	shadowAnnotation = "muting/shadow"
+)
+
+// ShadowOptions selects when rewrites are computed and reported but not
+// applied. Rules can also opt in individually. Namespace labels are read from
+// the lister when set, falling back to the client.
+type ShadowOptions struct {
+	Enabled bool
+	Label   string
+	Lister  corelisters.NamespaceLister
+	Client  typedcorev1.NamespacesGetter
+}
+
+// enabled reports whether every rewrite in the namespace is shadowed.
+func (o ShadowOptions) enabled(ctx context.Context, namespace string) (bool, error) {
+	if o.Enabled {
+		return true, nil
+	}
+
+	if o.Label == "" || o.Client == nil {
+		return false, nil
+	}
+
+	ns, err := getNamespace(ctx, o.Lister, o.Client, namespace)
+	if err != nil {
+		return false, fmt.Errorf("unable to get namespace: %v: %w", namespace, err)
+	}
+
+	return ns.Labels[o.Label] == NamespaceShadow, nil
+}
+
+// splitChanges separates applied changes from shadowed changes.
+func splitChanges(changes []HostChange) (applied, shadowed []HostChange) {
+	for _, c := range changes {
+		if c.Shadow {
+			shadowed = append(shadowed, c)
+			continue
+		}
+		applied = append(applied, c)
+	}
+
+	return applied, shadowed
+}
+
+// annotateShadow describes the shadowed changes on the object, removing a
+// stale description when there are none.
+func annotateShadow(obj metav1.Object, shadowed []HostChange) {
+	anns := obj.GetAnnotations()
+
+	if len(shadowed) == 0 {
+		if _, ok := anns[shadowAnnotation]; ok {
+			delete(anns, shadowAnnotation)
+			obj.SetAnnotations(anns)
+		}
+		return
+	}
+
+	var strs []string
+	for _, c := range shadowed {
+		strs = append(strs, c.String())
+	}
+
+	if anns == nil {
+		anns = make(map[string]string)
+	}
+	anns[shadowAnnotation] = strings.Join(strs, ", ")
+	obj.SetAnnotations(anns)
+}
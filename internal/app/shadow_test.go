@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMutatorShadow(t *testing.T) {
+	const (
+		rules = "- name: example\n  from: [example.org]\n  to: example.net\n"
+		rule  = "- name: example\n  from: [example.org]\n  to: example.net\n  shadow: true\n"
+		want  = "muting.example.org => muting.example.net (rule example)"
+	)
+
+	tests := map[string]struct {
+		rules          string
+		global         bool
+		label          string
+		lister         bool
+		dryRun         bool
+		annotations    map[string]string
+		wantHost       string
+		wantAnnotation string
+		wantShadowed   float64
+	}{
+		"disabled": {
+			rules:    rules,
+			wantHost: "muting.example.net",
+		},
+		"global": {
+			rules:          rules,
+			global:         true,
+			wantHost:       "muting.example.org",
+			wantAnnotation: want,
+			wantShadowed:   1,
+		},
+		"rule": {
+			rules:          rule,
+			wantHost:       "muting.example.org",
+			wantAnnotation: want,
+			wantShadowed:   1,
+		},
+		"namespace label": {
+			rules:          rules,
+			label:          NamespaceShadow,
+			wantHost:       "muting.example.org",
+			wantAnnotation: want,
+			wantShadowed:   1,
+		},
+		"namespace lister": {
+			rules:          rules,
+			label:          NamespaceShadow,
+			lister:         true,
+			wantHost:       "muting.example.org",
+			wantAnnotation: want,
+			wantShadowed:   1,
+		},
+		"namespace enabled": {
+			rules:    rules,
+			label:    NamespaceEnabled,
+			wantHost: "muting.example.net",
+		},
+		"dry run": {
+			rules:          rules,
+			global:         true,
+			dryRun:         true,
+			wantHost:       "muting.example.org",
+			wantAnnotation: want,
+		},
+		"stale annotation": {
+			rules:       rules,
+			annotations: map[string]string{shadowAnnotation: want},
+			wantHost:    "muting.example.net",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "default",
+					Labels: map[string]string{"muting": tc.label},
+				},
+			}
+
+			so := ShadowOptions{
+				Enabled: tc.global,
+				Label:   "muting",
+				Client:  fake.NewSimpleClientset(ns).CoreV1(),
+			}
+			if tc.lister {
+				indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+				if err := indexer.Add(ns); err != nil {
+					t.Fatalf("unable to add namespace: %v", err)
+				}
+				so.Lister = corelisters.NewNamespaceLister(indexer)
+				so.Client = fake.NewSimpleClientset().CoreV1()
+			}
+
+			m, err := newWebhookMetrics(prometheus.NewRegistry())
+			if err != nil {
+				t.Fatalf("newWebhookMetrics() error = %v", err)
+			}
+
+			mutate := mutatorFunc(WebhookOptions{
+				Transformer: newTestTransforms(t, newTestConfigMap(tc.rules)),
+				Shadow:      so,
+				Events:      noopEventRecorder{},
+				Collisions:  noopCollisionChecker{},
+				Log:         logr.Discard(),
+			}, m)
+
+			ing := &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "muting",
+					Annotations: tc.annotations,
+				},
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "muting.example.org"}},
+				},
+			}
+
+			ar := &kwhmodel.AdmissionReview{
+				Namespace: "default",
+				Name:      "muting",
+				DryRun:    tc.dryRun,
+			}
+
+			res, err := mutate(context.Background(), ar, ing)
+			if err != nil {
+				t.Fatalf("mutate() error = %v", err)
+			}
+
+			got := res.MutatedObject.(*networkingv1.Ingress)
+
+			if diff := cmp.Diff(tc.wantHost, got.Spec.Rules[0].Host); diff != "" {
+				t.Errorf("host mismatch (-want +got):\n%v", diff)
+			}
+
+			if diff := cmp.Diff(tc.wantAnnotation, got.Annotations[shadowAnnotation]); diff != "" {
+				t.Errorf("annotation mismatch (-want +got):\n%v", diff)
+			}
+
+			if tc.wantAnnotation != "" && len(res.Warnings) == 0 {
+				t.Errorf("warnings are empty")
+			}
+
+			if got := testutil.ToFloat64(m.Shadowed.WithLabelValues("example", "default")); got != tc.wantShadowed {
+				t.Errorf("shadowed = %v, want %v", got, tc.wantShadowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// SourceConfigMap reads rules from the cluster wide config map.
+	SourceConfigMap = "configmap"
+	// SourceNamespaces reads rules from config maps selected by label in any
+	// namespace. Their rules only apply to Ingresses in the same namespace.
+	SourceNamespaces = "namespaces"
+	// SourceFile reads rules from a local file.
+	SourceFile = "file"
+	// SourceURL reads rules from an HTTP(S) URL.
+	SourceURL = "url"
+
+	transformsKey = "transforms"
+
+	// versionAbsent is the version of a config map that does not exist so
+	// that its empty rules are cached like any other version.
+	versionAbsent = "absent"
+
+	urlSourceTimeout = 10 * time.Second
+)
+
+var (
+	ErrSourceUnknown       = errors.New("unknown rule source")
+	ErrSourceUnavailable   = errors.New("rule source unavailable")
+	ErrConfigMapsNotSynced = errors.New("config maps not synced")
+)
+
+// RuleSource provides transforms from a single location. Sources are merged
+// in the order they are declared with earlier sources taking precedence.
+type RuleSource interface {
+	// Name identifies the source in logs and on the debug endpoint.
+	Name() string
+	// Load returns the transforms and a version that changes whenever the
+	// transforms do. An empty version disables caching.
+	Load(ctx context.Context) (string, []Transform, error)
+}
+
+// sourceStarter is implemented by rule sources that watch or poll their
+// location in the background. Start is called once before the first Load.
+type sourceStarter interface {
+	Start(ctx context.Context) error
+}
+
+// SourceStatus reports the outcome of the last load of a rule source.
+type SourceStatus struct {
+	Source  string    `json:"source"`
+	Version string    `json:"version,omitempty"`
+	Rules   int       `json:"rules"`
+	Loaded  time.Time `json:"loaded,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// sourceState is the last successful load of a rule source and the status of
+// the most recent attempt.
+type sourceState struct {
+	version    string
+	transforms []Transform
+	ok         bool
+	status     SourceStatus
+}
+
+func newRuleSources(o TransformOptions, m *TransformMetrics) ([]RuleSource, error) {
+	kinds := o.Sources
+	if len(kinds) == 0 {
+		kinds = []string{SourceConfigMap}
+	}
+
+	var ss []RuleSource
+	for _, kind := range kinds {
+		switch kind {
+		case SourceConfigMap:
+			ss = append(ss, &configMapSource{
+				client:    o.Client,
+				namespace: o.Namespace,
+				name:      o.Name,
+				metrics:   m,
+				log:       o.Log,
+			})
+		case SourceNamespaces:
+			ss = append(ss, &namespacesSource{
+				client:   o.Client,
+				selector: o.Selector,
+				log:      o.Log,
+			})
+		case SourceFile:
+			if o.File == "" {
+				return nil, fmt.Errorf("%w: %v: no path", ErrSourceUnavailable, kind)
+			}
+			ss = append(ss, &fileSource{path: o.File, log: o.Log})
+		case SourceURL:
+			if o.URL == "" {
+				return nil, fmt.Errorf("%w: %v: no url", ErrSourceUnavailable, kind)
+			}
+			ss = append(ss, &urlSource{
+				url:      o.URL,
+				interval: o.Interval,
+				client:   &http.Client{Timeout: urlSourceTimeout},
+				log:      o.Log,
+			})
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrSourceUnknown, kind)
+		}
+	}
+
+	return ss, nil
+}
+
+// withSource records the origin of each transform.
+func withSource(tt []Transform, source, scope string) []Transform {
+	for idx := range tt {
+		tt[idx].Source = source
+		tt[idx].Scope = scope
+	}
+
+	return tt
+}
+
+type configMapSource struct {
+	client    TransformClient
+	namespace string
+	name      string
+	metrics   *TransformMetrics
+	log       logr.Logger
+
+	mu         sync.Mutex
+	configMaps cache.Indexer
+	version    string
+	transforms []Transform
+}
+
+func (s *configMapSource) Name() string {
+	return fmt.Sprintf("%v:%v/%v", SourceConfigMap, s.namespace, s.name)
+}
+
+// Start runs an informer of the config map and waits for it to sync so that
+// loads are served from the cache instead of the API server.
+func (s *configMapSource) Start(ctx context.Context) error {
+	selector := fields.OneTermEqualSelector(metav1.ObjectNameField, s.name).String()
+
+	lw := &cache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			o.FieldSelector = selector
+			return s.client.ConfigMaps(s.namespace).List(ctx, o)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			o.FieldSelector = selector
+			return s.client.ConfigMaps(s.namespace).Watch(ctx, o)
+		},
+	}
+
+	inf := cache.NewSharedIndexInformer(lw, &corev1.ConfigMap{}, informerResync, cache.Indexers{})
+	go inf.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), inf.HasSynced) {
+		return ErrConfigMapsNotSynced
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.configMaps = inf.GetIndexer()
+
+	return nil
+}
+
+// Load reads the config map from the informer once started, otherwise from
+// the API server.
+func (s *configMapSource) Load(ctx context.Context) (string, []Transform, error) {
+	cm, err := s.get(ctx)
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{}
+		cm.ResourceVersion = versionAbsent
+		err = nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to get config map: %v: %w", s.name, err)
+	}
+
+	version := cm.ResourceVersion
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version != "" && version == s.version {
+		return s.version, s.transforms, nil
+	}
+
+	s.metrics.setModified(cm)
+
+	tt, err := loadTransforms(cm.Data[transformsKey], s.log.WithValues("source", s.Name()))
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.version = version
+	s.transforms = withSource(tt, s.Name(), "")
+
+	return s.version, s.transforms, nil
+}
+
+func (s *configMapSource) get(ctx context.Context) (*corev1.ConfigMap, error) {
+	s.mu.Lock()
+	indexer := s.configMaps
+	s.mu.Unlock()
+
+	if indexer == nil {
+		return s.client.ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	}
+
+	return corelisters.NewConfigMapLister(indexer).ConfigMaps(s.namespace).Get(s.name)
+}
+
+type namespacesSource struct {
+	client   TransformClient
+	selector string
+	log      logr.Logger
+
+	mu         sync.Mutex
+	configMaps cache.Indexer
+	version    string
+	transforms []Transform
+}
+
+func (s *namespacesSource) Name() string {
+	return fmt.Sprintf("%v:%v", SourceNamespaces, s.selector)
+}
+
+// Start runs an informer of the selected config maps and waits for it to
+// sync.
+func (s *namespacesSource) Start(ctx context.Context) error {
+	lw := &cache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			o.LabelSelector = s.selector
+			return s.client.ConfigMaps(metav1.NamespaceAll).List(ctx, o)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			o.LabelSelector = s.selector
+			return s.client.ConfigMaps(metav1.NamespaceAll).Watch(ctx, o)
+		},
+	}
+
+	inf := cache.NewSharedIndexInformer(lw, &corev1.ConfigMap{}, informerResync, cache.Indexers{})
+	go inf.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), inf.HasSynced) {
+		return ErrConfigMapsNotSynced
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.configMaps = inf.GetIndexer()
+
+	return nil
+}
+
+// Load merges the rules of every selected config map ordered by namespace and
+// name. Config maps with invalid rules are skipped so that one namespace
+// cannot break the rules of the others.
+func (s *namespacesSource) Load(ctx context.Context) (string, []Transform, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.configMaps == nil {
+		return "", nil, ErrConfigMapsNotSynced
+	}
+
+	var items []*corev1.ConfigMap
+	for _, obj := range s.configMaps.List() {
+		if cm, ok := obj.(*corev1.ConfigMap); ok {
+			items = append(items, cm)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Namespace != items[j].Namespace {
+			return items[i].Namespace < items[j].Namespace
+		}
+		return items[i].Name < items[j].Name
+	})
+
+	var versions []string
+	for _, cm := range items {
+		versions = append(versions, fmt.Sprintf("%v/%v@%v", cm.Namespace, cm.Name, cm.ResourceVersion))
+	}
+
+	version := digest(strings.Join(versions, ","))
+	if version == s.version {
+		return s.version, s.transforms, nil
+	}
+
+	var tt []Transform
+	for _, cm := range items {
+		source := fmt.Sprintf("%v:%v/%v", SourceNamespaces, cm.Namespace, cm.Name)
+
+		ts, err := loadTransforms(cm.Data[transformsKey], s.log.WithValues("source", source))
+		if err != nil {
+			s.log.Error(err, "Unable to load namespace rules.", "source", source)
+			continue
+		}
+
+		tt = append(tt, withSource(ts, source, cm.Namespace)...)
+	}
+
+	s.version = version
+	s.transforms = tt
+
+	return s.version, s.transforms, nil
+}
+
+type fileSource struct {
+	path string
+	log  logr.Logger
+
+	mu         sync.Mutex
+	version    string
+	transforms []Transform
+}
+
+func (s *fileSource) Name() string {
+	return fmt.Sprintf("%v:%v", SourceFile, s.path)
+}
+
+func (s *fileSource) Load(ctx context.Context) (string, []Transform, error) {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to stat file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version := fmt.Sprintf("%v-%v", fi.ModTime().UnixNano(), fi.Size())
+	if version == s.version {
+		return s.version, s.transforms, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read file: %w", err)
+	}
+
+	tt, err := loadTransforms(string(data), s.log.WithValues("source", s.Name()))
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.version = version
+	s.transforms = withSource(tt, s.Name(), "")
+
+	return s.version, s.transforms, nil
+}
+
+// urlSource fetches rules in the background once per interval and serves the
+// result of the last fetch, including a failure, so that an unavailable
+// server never adds latency to an admission.
+type urlSource struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	log      logr.Logger
+
+	mu         sync.Mutex
+	fetched    time.Time
+	etag       string
+	version    string
+	transforms []Transform
+	err        error
+}
+
+func (s *urlSource) Name() string {
+	return fmt.Sprintf("%v:%v", SourceURL, s.url)
+}
+
+// Start fetches the rules and then refreshes them every interval until the
+// context is done.
+func (s *urlSource) Start(ctx context.Context) error {
+	s.refresh(ctx)
+
+	if s.interval <= 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.refresh(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *urlSource) Load(ctx context.Context) (string, []Transform, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fetched.IsZero() {
+		return "", nil, fmt.Errorf("%w: %v: not fetched", ErrSourceUnavailable, s.url)
+	}
+
+	return s.version, s.transforms, s.err
+}
+
+// refresh fetches the rules, keeping the last good rules when the fetch
+// fails.
+func (s *urlSource) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, urlSourceTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	etag := s.etag
+	s.mu.Unlock()
+
+	res, err := s.fetch(ctx, etag)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetched = time.Now()
+	s.err = err
+	if err != nil || res == nil {
+		return
+	}
+
+	s.etag = res.etag
+	s.version = res.version
+	s.transforms = res.transforms
+}
+
+// urlFetch is the result of a fetch that returned new rules.
+type urlFetch struct {
+	etag       string
+	version    string
+	transforms []Transform
+}
+
+// fetch gets the rules from the URL. It returns nil when the rules are not
+// modified since the fetch that returned etag.
+func (s *urlSource) fetch(ctx context.Context, etag string) (*urlFetch, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %w", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get url: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: %v: %v", ErrSourceUnavailable, s.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response: %w", err)
+	}
+
+	tt, err := loadTransforms(string(data), s.log.WithValues("source", s.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &urlFetch{
+		etag:       resp.Header.Get("ETag"),
+		version:    digest(string(data)),
+		transforms: withSource(tt, s.Name(), ""),
+	}, nil
+}
+
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// SourceStatus returns the status of every rule source in precedence order.
+func (ts *Transforms) SourceStatus() []SourceStatus {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ss := make([]SourceStatus, len(ts.states))
+	for idx, st := range ts.states {
+		ss[idx] = st.status
+	}
+
+	return ss
+}
+
+// SourcesHandler serves the status of every rule source as JSON.
+func (ts *Transforms) SourcesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ts.SourceStatus())
+	})
+}
@@ -0,0 +1,290 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTransformsSources(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "transforms.yaml")
+	if err := os.WriteFile(file, []byte("- from: [example.com]\n  to: example.net\n"), 0o644); err != nil {
+		t.Fatalf("unable to write rules file: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	team := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "team",
+			Name:      "rules",
+			Labels:    map[string]string{"muting/transforms": "true"},
+		},
+		Data: map[string]string{
+			"transforms": "- from: [example.org]\n  to: team.example.net\n- from: [other.org]\n  to: other.net\n",
+		},
+	}
+
+	ts, err := newTransformer(TransformOptions{
+		Namespace: testNamespace,
+		Name:      testName,
+		Sources:   []string{SourceConfigMap, SourceNamespaces, SourceFile, SourceURL},
+		Selector:  "muting/transforms=true",
+		File:      file,
+		URL:       srv.URL,
+		Interval:  time.Minute,
+		Client: fake.NewSimpleClientset(
+			newTestConfigMap("- from: [example.org]\n  to: example.net\n"),
+			team,
+		).CoreV1(),
+		Metrics: prometheus.NewRegistry(),
+		Log:     logr.Discard(),
+	})
+	if err != nil {
+		t.Fatalf("newTransformer() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := ts.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	tests := map[string]struct {
+		namespace string
+		host      string
+		want      string
+	}{
+		"cluster precedence":     {namespace: "team", host: "a.example.org", want: "a.example.net"},
+		"namespace rule":         {namespace: "team", host: "a.other.org", want: "a.other.net"},
+		"namespace rule scoped":  {namespace: testNamespace, host: "a.other.org", want: "a.other.org"},
+		"file rule":              {namespace: testNamespace, host: "a.example.com", want: "a.example.net"},
+		"failed source isolated": {namespace: testNamespace, host: "a.example.org", want: "a.example.net"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ts.Transform(context.Background(), TransformRequest{Namespace: tc.namespace, Host: tc.host})
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+
+			if got.Host != tc.want {
+				t.Errorf("Transform() host = %v, want %v", got.Host, tc.want)
+			}
+		})
+	}
+
+	want := []SourceStatus{
+		{Source: "configmap:muting/muting", Rules: 1},
+		{Source: "namespaces:muting/transforms=true", Rules: 2},
+		{Source: "file:" + file, Rules: 1},
+		{Source: "url:" + srv.URL},
+	}
+
+	got := ts.SourceStatus()
+	if got[3].Error == "" {
+		t.Errorf("SourceStatus() url error is empty")
+	}
+
+	opts := cmpopts.IgnoreFields(SourceStatus{}, "Version", "Loaded", "Error")
+	if diff := cmp.Diff(want, got, opts); diff != "" {
+		t.Errorf("SourceStatus() mismatch (-want +got):\n%v", diff)
+	}
+}
+
+func TestURLSource(t *testing.T) {
+	var requests int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("- from: [example.org]\n  to: example.net\n"))
+	}))
+	defer srv.Close()
+
+	s := &urlSource{url: srv.URL, interval: time.Hour, client: srv.Client(), log: logr.Discard()}
+
+	if _, _, err := s.Load(context.Background()); !errors.Is(err, ErrSourceUnavailable) {
+		t.Fatalf("Load() before start error = %v, want %v", err, ErrSourceUnavailable)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		version, tt, err := s.Load(context.Background())
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if version == "" || len(tt) != 1 {
+			t.Fatalf("Load() = %q, %v", version, tt)
+		}
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("requests = %v, want 1", got)
+	}
+
+	s.refresh(ctx)
+	version, tt, err := s.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load() not modified error = %v", err)
+	}
+	if version == "" || len(tt) != 1 {
+		t.Errorf("Load() not modified = %q, %v", version, tt)
+	}
+
+	srv.Close()
+	s.refresh(ctx)
+	version, tt, err = s.Load(context.Background())
+	if err == nil {
+		t.Errorf("Load() unavailable error = nil")
+	}
+	if version == "" || len(tt) != 1 {
+		t.Errorf("Load() unavailable = %q, %v, want last good rules", version, tt)
+	}
+}
+
+func TestConfigMapSourceNotFound(t *testing.T) {
+	m, err := newTransformMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("newTransformMetrics() error = %v", err)
+	}
+
+	s := &configMapSource{
+		client:    fake.NewSimpleClientset().CoreV1(),
+		namespace: testNamespace,
+		name:      testName,
+		metrics:   m,
+		log:       logr.Discard(),
+	}
+
+	version, tt, err := s.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if version == "" || len(tt) != 0 {
+		t.Errorf("Load() = %q, %v, want a cacheable version and no rules", version, tt)
+	}
+}
+
+func TestConfigMapSourceStarted(t *testing.T) {
+	m, err := newTransformMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("newTransformMetrics() error = %v", err)
+	}
+
+	client := fake.NewSimpleClientset()
+
+	s := &configMapSource{
+		client:    client.CoreV1(),
+		namespace: testNamespace,
+		name:      testName,
+		metrics:   m,
+		log:       logr.Discard(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	version, _, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if version != versionAbsent {
+		t.Errorf("Load() version = %q, want %q", version, versionAbsent)
+	}
+
+	cm := newTestConfigMap("- from: [example.org]\n  to: example.net\n")
+	cm.ResourceVersion = "1"
+	if _, err := client.CoreV1().ConfigMaps(testNamespace).Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unable to create config map: %v", err)
+	}
+
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		_, tt, err := s.Load(ctx)
+		return len(tt) == 1, err
+	})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "get" && a.GetResource().Resource == "configmaps" {
+			t.Errorf("Load() got config map from the API server")
+		}
+	}
+}
+
+func TestSourcesHandler(t *testing.T) {
+	ts := newTestTransforms(t, newTestConfigMap("- from: [example.org]\n  to: example.net\n"))
+	if _, err := ts.read(context.Background()); err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+
+	r := getOpsRouter(prometheus.NewRegistry(), newHealth(HealthOptions{}), ts.SourcesHandler(), ts.RulesHandler(), true, logr.Discard())
+
+	for _, path := range []string{"/debug/sources", "/rules", "/debug/pprof/"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %v status = %v", path, rec.Code)
+		}
+	}
+}
+
+func TestConfigMapSourceUnknownFields(t *testing.T) {
+	m, err := newTransformMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("newTransformMetrics() error = %v", err)
+	}
+
+	s := &configMapSource{
+		client:    fake.NewSimpleClientset(newTestConfigMap("- from: [example.org]\n  to: example.net\n  future: true\n")).CoreV1(),
+		namespace: testNamespace,
+		name:      testName,
+		metrics:   m,
+		log:       logr.Discard(),
+	}
+
+	_, tt, err := s.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(tt) != 1 {
+		t.Errorf("Load() rules = %v, want 1", len(tt))
+	}
+}
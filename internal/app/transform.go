@@ -2,100 +2,589 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/go-logr/logr"
+	"github.com/mikelorant/muting2/internal/suffix"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 	"gopkg.in/yaml.v3"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 type Transforms struct {
-	Client  corev1.ConfigMapsGetter
+	Client  TransformClient
+	Sources []RuleSource
+	Metrics *TransformMetrics
+	Loaded  *HealthGate
 	Options TransformOptions
+
+	mu         sync.Mutex
+	rules      *Rules
+	states     []sourceState
+	hits       sync.Map
+	namespaces corelisters.NamespaceLister
+}
+
+// Rules is the set of transforms from a version of the config map compiled
+// for matching.
+type Rules struct {
+	Version    string
+	Transforms []Transform
+	Trie       *suffix.Trie[ruleRef]
+	Warnings   []string
+
+	legacy []legacyRule
 }
 
 type Transform struct {
-	From []string `yaml:"from"`
-	To   string   `yaml:"to"`
+	Name      string   `yaml:"name,omitempty"`
+	From      []string `yaml:"from"`
+	To        string   `yaml:"to"`
+	Matching  string   `yaml:"matching,omitempty"`
+	Wildcards string   `yaml:"wildcards,omitempty"`
+	Shadow    bool     `yaml:"shadow,omitempty"`
+	Update    string   `yaml:"update,omitempty"`
+
+	// IngressClassName and Annotations are set on Ingresses with a host
+	// rewritten by the rule. Existing annotations are kept unless
+	// OverrideAnnotations is set.
+	IngressClassName    string            `yaml:"ingressClassName,omitempty"`
+	Annotations         map[string]string `yaml:"annotations,omitempty"`
+	OverrideAnnotations bool              `yaml:"overrideAnnotations,omitempty"`
+
+	// IngressClasses restricts the rule to Ingresses of the listed classes.
+	IngressClasses []string `yaml:"ingressClasses,omitempty"`
+
+	// Source names the rule source the transform was loaded from and Scope
+	// restricts it to a single namespace when set.
+	Source string `yaml:"-"`
+	Scope  string `yaml:"-"`
+
+	NamespacePolicy `yaml:",inline"`
+}
+
+type TransformClient interface {
+	typedcorev1.ConfigMapsGetter
+	typedcorev1.NamespacesGetter
+}
+
+// TransformRequest is a host of an object under admission.
+type TransformRequest struct {
+	Namespace string
+	Class     string
+	Host      string
+	DryRun    bool
+	Previous  previousHosts
+}
+
+type TransformResult struct {
+	Host      string
+	Matched   bool
+	Rule      string
+	Transform Transform
 }
 
 type TransformOptions struct {
 	Namespace string
 	Name      string
-	Client    corev1.ConfigMapsGetter
+	Sources   []string
+	Selector  string
+	File      string
+	URL       string
+	Interval  time.Duration
+	Matching  string
+	Encoding  string
+	Client    TransformClient
+	Metrics   prometheus.Registerer
+	Log       logr.Logger
 }
 
+var (
+	ErrTransformsNotLoaded = errors.New("transforms not loaded")
+	ErrTransformFromEmpty  = errors.New("transform has no from")
+	ErrInvalidHost         = errors.New("invalid host")
+	ErrNamespacesNotSynced = errors.New("namespaces not synced")
+)
+
 func newTransformer(o TransformOptions) (*Transforms, error) {
+	if err := validateMatching(o.Matching); err != nil {
+		return nil, err
+	}
+
+	if err := validateEncoding(o.Encoding); err != nil {
+		return nil, err
+	}
+
+	m, err := newTransformMetrics(o.Metrics)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create metrics: %w", err)
+	}
+
+	ss, err := newRuleSources(o, m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create rule sources: %w", err)
+	}
+
 	ts := Transforms{
 		Options: o,
 		Client:  o.Client,
+		Sources: ss,
+		Metrics: m,
+		Loaded:  newHealthGate(ErrTransformsNotLoaded),
+		states:  make([]sourceState, len(ss)),
 	}
 
 	return &ts, nil
 }
 
-func (ts *Transforms) Transform(ctx context.Context, str string) (string, error) {
+// Start runs the namespace informer used by namespace policies and waits for
+// it to sync, then starts the rule sources that load in the background.
+func (ts *Transforms) Start(ctx context.Context) error {
+	lw := &cache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			return ts.Client.Namespaces().List(ctx, o)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			return ts.Client.Namespaces().Watch(ctx, o)
+		},
+	}
+
+	inf := cache.NewSharedIndexInformer(lw, &corev1.Namespace{}, informerResync, cache.Indexers{})
+	go inf.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), inf.HasSynced) {
+		return ErrNamespacesNotSynced
+	}
+
+	ts.namespaces = corelisters.NewNamespaceLister(inf.GetIndexer())
+
+	for _, src := range ts.Sources {
+		if s, ok := src.(sourceStarter); ok {
+			if err := s.Start(ctx); err != nil {
+				return fmt.Errorf("unable to start rule source: %v: %w", src.Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// UsesIngressClasses reports whether any loaded rule is restricted to ingress
+// classes. It is true until the rules are loaded.
+func (ts *Transforms) UsesIngressClasses() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.rules == nil {
+		return true
+	}
+
+	for _, t := range ts.rules.Transforms {
+		if len(t.IngressClasses) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Transform rewrites a host of an object in the namespace with the first
+// matching rule. Hosts of an updated object are only rewritten when the
+// update policy of the rule allows it given the previous hosts. Dry runs are
+// not counted in metrics or rule hits.
+func (ts *Transforms) Transform(ctx context.Context, req TransformRequest) (TransformResult, error) {
 	ctx, span := otel.Tracer(name).Start(ctx, "Transform")
 	defer span.End()
 
-	tt, err := ts.read(ctx)
+	rs, err := ts.load(ctx)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		return str, fmt.Errorf("unable to read config map: %w", err)
+		ts.observe(req, "", outcomeError)
+		return TransformResult{Host: req.Host}, fmt.Errorf("unable to load rules: %w", err)
 	}
 
-	for _, t := range tt {
-		for _, suffix := range t.From {
-			if !strings.HasSuffix(str, suffix) {
-				continue
-			}
+	nl := &namespaceLabels{
+		client:    ts.Client,
+		lister:    ts.namespaces,
+		namespace: req.Namespace,
+	}
+
+	res, err := ts.transform(ctx, rs, nl, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return res, err
+	}
+
+	return res, nil
+}
+
+// observe counts the outcome of a transform unless it is a dry run.
+func (ts *Transforms) observe(req TransformRequest, rule, outcome string) {
+	if req.DryRun {
+		return
+	}
+
+	ts.Metrics.observe(rule, req.Namespace, outcome)
+}
+
+func (ts *Transforms) transform(ctx context.Context, rs *Rules, nl *namespaceLabels, req TransformRequest) (TransformResult, error) {
+	req.Namespace = nl.namespace
+	str := req.Host
+
+	// Hosts that cannot be normalized are not valid Ingress hosts and are
+	// left for the API server to reject.
+	normalized, err := normalizeHost(str)
+	if err != nil {
+		ts.observe(req, "", outcomeUnchanged)
+		return TransformResult{Host: str}, nil
+	}
+
+	for _, m := range rs.match(normalized) {
+		t := rs.Transforms[m.Value.index]
+		rule := ruleLabel(m.Value.index, t)
+
+		if t.Scope != "" && t.Scope != req.Namespace {
+			ts.observe(req, rule, outcomeSkipped)
+			continue
+		}
 
-			from := fmt.Sprintf(".%v$", suffix)
-			to := fmt.Sprintf("$1.%v", t.To)
+		if len(t.IngressClasses) != 0 && !contains(t.IngressClasses, req.Class) {
+			ts.observe(req, rule, outcomeSkipped)
+			continue
+		}
+
+		ok, err := t.allows(ctx, nl)
+		if err != nil {
+			ts.observe(req, rule, outcomeError)
+			return TransformResult{Host: str}, fmt.Errorf("unable to evaluate namespace policy: %w", err)
+		}
+		if !ok && t.deny() {
+			ts.observe(req, rule, outcomeDenied)
+			return TransformResult{Host: str}, fmt.Errorf("%w: rule %v: %v", ErrNamespaceNotAllowed, rule, req.Namespace)
+		}
+		if !ok {
+			ts.observe(req, rule, outcomeSkipped)
+			continue
+		}
+
+		wildcard := isWildcard(normalized)
+
+		if wildcard && t.wildcards() == WildcardIgnore {
+			ts.observe(req, rule, outcomeSkipped)
+			return TransformResult{Host: str}, nil
+		}
+		if wildcard && t.wildcards() == WildcardReject {
+			ts.observe(req, rule, outcomeDenied)
+			return TransformResult{Host: str}, fmt.Errorf("%w: rule %v: %v", ErrWildcardRejected, rule, str)
+		}
+
+		if !req.Previous.allows(t, str) {
+			ts.observe(req, rule, outcomeSkipped)
+			return TransformResult{Host: str}, nil
+		}
 
-			re := regexp.MustCompile(from)
-			return re.ReplaceAllString(str, to), nil
+		host := rs.rewrite(m, normalized)
+
+		validate := validateHost
+		if wildcard {
+			validate = validateWildcardHost
+		}
+
+		if err := validate(host); err != nil {
+			ts.observe(req, rule, outcomeError)
+			return TransformResult{Host: str}, fmt.Errorf("unable to rewrite host: %v: rule %v: %w", str, rule, err)
 		}
+
+		host, err = encodeHost(host, ts.Options.Encoding)
+		if err != nil {
+			ts.observe(req, rule, outcomeError)
+			return TransformResult{Host: str}, fmt.Errorf("unable to encode host: %v: rule %v: %w", str, rule, err)
+		}
+
+		ts.observe(req, rule, outcomeMatched)
+		if !req.DryRun {
+			ts.hit(t)
+		}
+
+		return TransformResult{
+			Host:      host,
+			Matched:   true,
+			Rule:      rule,
+			Transform: t,
+		}, nil
 	}
 
-	return str, nil
+	ts.observe(req, "", outcomeUnchanged)
+
+	return TransformResult{Host: str}, nil
 }
 
 func (t Transform) String() string {
+	if t.Name != "" {
+		return fmt.Sprintf("%v: %v => %v", t.Name, strings.Join(t.From, ", "), t.To)
+	}
+
 	return fmt.Sprintf("%v => %v", strings.Join(t.From, ", "), t.To)
 }
 
+func (t Transform) validate() error {
+	if len(t.From) == 0 {
+		return ErrTransformFromEmpty
+	}
+
+	for _, suffix := range t.From {
+		if err := validateHost(suffix); err != nil {
+			return fmt.Errorf("invalid from: %w", err)
+		}
+	}
+
+	if err := validateHost(t.To); err != nil {
+		return fmt.Errorf("invalid to: %w", err)
+	}
+
+	if err := validateMatching(t.Matching); err != nil {
+		return err
+	}
+
+	if err := validateWildcards(t.Wildcards); err != nil {
+		return err
+	}
+
+	if err := validateUpdate(t.Update); err != nil {
+		return err
+	}
+
+	if t.IngressClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(t.IngressClassName); len(errs) != 0 {
+			return fmt.Errorf("invalid ingress class name: %q: %v", t.IngressClassName, strings.Join(errs, ", "))
+		}
+	}
+
+	for _, class := range t.IngressClasses {
+		if errs := validation.IsDNS1123Subdomain(class); len(errs) != 0 {
+			return fmt.Errorf("invalid ingress class: %q: %v", class, strings.Join(errs, ", "))
+		}
+	}
+
+	for k := range t.Annotations {
+		if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+			return fmt.Errorf("invalid annotation: %q: %v", k, strings.Join(errs, ", "))
+		}
+	}
+
+	return t.NamespacePolicy.validate()
+}
+
+// validateHost checks that a host is a valid RFC 1123 subdomain.
+func validateHost(host string) error {
+	if errs := validation.IsDNS1123Subdomain(host); len(errs) != 0 {
+		return fmt.Errorf("%w: %q: %v", ErrInvalidHost, host, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
 func (ts *Transforms) read(ctx context.Context) ([]Transform, error) {
-	ctx, span := otel.Tracer(name).Start(ctx, "read")
+	rs, err := ts.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.Transforms, nil
+}
+
+// load returns the compiled rules merged from every source, only compiling
+// the transforms when the version of a source changes. A source that fails
+// keeps its last loaded transforms so that the others remain in effect.
+func (ts *Transforms) load(ctx context.Context) (*Rules, error) {
+	ctx, span := otel.Tracer(name).Start(ctx, "load")
 	defer span.End()
 
-	var tt []Transform
+	timer := prometheus.NewTimer(ts.Metrics.LoadDuration)
+	defer timer.ObserveDuration()
 
-	cl := ts.Client.ConfigMaps(ts.Options.Namespace)
+	type result struct {
+		version string
+		tt      []Transform
+		err     error
+	}
 
-	cm, err := cl.Get(ctx, ts.Options.Name, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		return tt, fmt.Errorf("unable to get config map: %v: %w", ts.Options.Name, err)
+	results := make([]result, len(ts.Sources))
+	for idx, src := range ts.Sources {
+		version, tt, err := src.Load(ctx)
+		results[idx] = result{version: version, tt: tt, err: err}
 	}
 
-	data, ok := cm.Data["transforms"]
-	if !ok {
-		return tt, nil
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var (
+		errs     []string
+		versions []string
+	)
+	for idx, res := range results {
+		st := &ts.states[idx]
+		st.status.Source = ts.Sources[idx].Name()
+
+		if res.err != nil {
+			span.RecordError(res.err)
+			ts.Metrics.ReadFailures.Inc()
+			errs = append(errs, fmt.Sprintf("%v: %v", st.status.Source, res.err))
+
+			if st.status.Error != res.err.Error() {
+				ts.Options.Log.Error(res.err, "Unable to load rule source.", "source", st.status.Source)
+			}
+			st.status.Error = res.err.Error()
+		} else {
+			if !st.ok || st.version != res.version {
+				st.status.Loaded = time.Now()
+			}
+			st.version, st.transforms, st.ok = res.version, res.tt, true
+			st.status.Version = res.version
+			st.status.Rules = len(res.tt)
+			st.status.Error = ""
+		}
+
+		if !st.ok {
+			versions = append(versions, "")
+			continue
+		}
+
+		versions = append(versions, st.version)
 	}
 
-	if err := yaml.Unmarshal([]byte(data), &tt); err != nil {
-		span.RecordError(err)
+	if len(errs) == len(ts.Sources) {
+		err := fmt.Errorf("%w: %v", ErrSourceUnavailable, strings.Join(errs, "; "))
 		span.SetStatus(codes.Error, err.Error())
-		return tt, fmt.Errorf("unable to unmarshal config map: %w", err)
+		return nil, err
+	}
+
+	version := strings.Join(versions, "/")
+	if ts.rules != nil && cacheable(versions) && ts.rules.Version == version {
+		return ts.rules, nil
+	}
+
+	// The rules are only merged when they changed as a cached load happens
+	// for every host under admission.
+	var tt []Transform
+	for _, st := range ts.states {
+		if st.ok {
+			tt = append(tt, st.transforms...)
+		}
+	}
+
+	ts.rules = compileRules(version, tt, ts.Options.Matching)
+
+	for _, w := range ts.rules.Warnings {
+		ts.Options.Log.Info("Rule matching differs from legacy behaviour.", "warning", w)
+	}
+
+	ts.Metrics.Rules.Set(float64(len(tt)))
+	ts.Metrics.initRules(tt)
+	ts.Loaded.Set(nil)
+
+	return ts.rules, nil
+}
+
+// cacheable reports whether every source has a version to compare.
+func cacheable(versions []string) bool {
+	for _, v := range versions {
+		if v == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// loadTransforms parses rules loaded at runtime. Unknown fields are ignored
+// with a warning so that a field that validation would reject does not stop
+// the rules from loading.
+func loadTransforms(data string, log logr.Logger) ([]Transform, error) {
+	tt, err := parseTransforms(data, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := parseTransforms(data, true); err != nil {
+		log.Info("Ignored unknown rule fields.", "error", err.Error())
+	}
+
+	return tt, nil
+}
+
+// parseTransforms decodes and validates rules, rejecting unknown fields when
+// strict.
+func parseTransforms(data string, strict bool) ([]Transform, error) {
+	var tt []Transform
+
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	dec.KnownFields(strict)
+
+	if err := dec.Decode(&tt); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to unmarshal transforms: %w", err)
+	}
+
+	for idx, t := range tt {
+		n, err := t.normalize()
+		if err == nil {
+			err = n.validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform: %v: %w", ruleLabel(idx, t), err)
+		}
+		tt[idx] = n
 	}
 
 	return tt, nil
 }
+
+// compileRules builds the suffix trie with earlier rules, and earlier
+// suffixes within a rule, taking precedence. Rules using legacy matching are
+// kept aside and scanned in order.
+func compileRules(version string, tt []Transform, mode string) *Rules {
+	rs := &Rules{
+		Version:    version,
+		Transforms: tt,
+		Trie:       suffix.New[ruleRef](),
+		Warnings:   migrationWarnings(tt, mode),
+	}
+
+	var priority int
+	for idx, t := range tt {
+		for _, from := range t.From {
+			if t.matching(mode) == MatchingLegacy {
+				rs.legacy = append(rs.legacy, legacyRule{
+					suffix:   from,
+					priority: priority,
+					ref: ruleRef{
+						index:  idx,
+						legacy: regexp.MustCompile(fmt.Sprintf(".%v$", from)),
+					},
+				})
+			} else {
+				rs.Trie.Insert(from, priority, ruleRef{index: idx})
+			}
+			priority++
+		}
+	}
+
+	return rs
+}
@@ -0,0 +1,488 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTransformsRead(t *testing.T) {
+	tests := map[string]struct {
+		objs    []runtime.Object
+		want    []Transform
+		wantErr bool
+	}{
+		"missing config map": {},
+		"missing transforms key": {
+			objs: []runtime.Object{&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName},
+			}},
+		},
+		"valid transforms": {
+			objs: []runtime.Object{newTestConfigMap(`
+- name: example
+  from: [example.org, example.com]
+  to: example.net
+`)},
+			want: []Transform{{
+				Name:   "example",
+				From:   []string{"example.org", "example.com"},
+				To:     "example.net",
+				Source: "configmap:muting/muting",
+			}},
+		},
+		"invalid yaml": {
+			objs:    []runtime.Object{newTestConfigMap(`- from: {`)},
+			wantErr: true,
+		},
+		"invalid suffix": {
+			objs: []runtime.Object{newTestConfigMap(`
+- from: [a(b]
+  to: example.net
+`)},
+			wantErr: true,
+		},
+		"invalid wildcards": {
+			objs: []runtime.Object{newTestConfigMap(`
+- from: [example.org]
+  to: example.net
+  wildcards: sometimes
+`)},
+			wantErr: true,
+		},
+		"invalid update": {
+			objs: []runtime.Object{newTestConfigMap(`
+- from: [example.org]
+  to: example.net
+  update: sometimes
+`)},
+			wantErr: true,
+		},
+		"invalid violation": {
+			objs: []runtime.Object{newTestConfigMap(`
+- from: [example.org]
+  to: example.net
+  onViolation: explode
+`)},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestTransforms(t, tt.objs...)
+
+			got, err := ts.read(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("read() mismatch (-want +got):\n%v", diff)
+			}
+
+			if err := ts.Loaded.Check(context.Background()); err != nil {
+				t.Errorf("Loaded.Check() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestTransformsTransform(t *testing.T) {
+	ts := newTestTransforms(t, newTestConfigMap(`
+- name: example
+  from: [example.org]
+  to: example.net
+`))
+
+	tests := map[string]struct {
+		host string
+		want TransformResult
+	}{
+		"matched": {
+			host: "muting.example.org",
+			want: TransformResult{
+				Host:    "muting.example.net",
+				Matched: true,
+				Rule:    "example",
+				Transform: Transform{
+					Name:   "example",
+					From:   []string{"example.org"},
+					To:     "example.net",
+					Source: "configmap:muting/muting",
+				},
+			},
+		},
+		"unchanged": {
+			host: "muting.example.com",
+			want: TransformResult{Host: "muting.example.com"},
+		},
+		"partial label": {
+			host: "notexample.org",
+			want: TransformResult{Host: "notexample.org"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ts.Transform(context.Background(), TransformRequest{Namespace: testNamespace, Host: tt.host})
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Transform() mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestTransformMatching(t *testing.T) {
+	tt := []Transform{{From: []string{"example.org"}, To: "example.net"}}
+
+	tests := map[string]struct {
+		mode string
+		host string
+		want string
+	}{
+		"label subdomain":      {mode: MatchingLabel, host: "a.example.org", want: "a.example.net"},
+		"label exact":          {mode: MatchingLabel, host: "example.org", want: "example.net"},
+		"label partial label":  {mode: MatchingLabel, host: "fooexample.org", want: "fooexample.org"},
+		"legacy subdomain":     {mode: MatchingLegacy, host: "a.example.org", want: "a.example.net"},
+		"legacy exact":         {mode: MatchingLegacy, host: "example.org", want: "example.org"},
+		"legacy partial label": {mode: MatchingLegacy, host: "fooexample.org", want: "fo.example.net"},
+	}
+
+	ts := newTestTransforms(t)
+	nl := &namespaceLabels{namespace: testNamespace}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ts.transform(context.Background(), compileRules("", tt, tc.mode), nl, TransformRequest{Host: tc.host})
+			if err != nil {
+				t.Fatalf("transform() error = %v", err)
+			}
+
+			if got.Host != tc.want {
+				t.Errorf("transform() host = %v, want %v", got.Host, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransformNormalization(t *testing.T) {
+	cm := newTestConfigMap(`
+- name: example
+  from: [Example.ORG]
+  to: example.net
+- name: idn
+  from: [bücher.example]
+  to: bücher.example.net
+`)
+
+	tests := map[string]struct {
+		encoding string
+		host     string
+		want     string
+	}{
+		"uppercase":        {host: "API.Example.ORG", want: "api.example.net"},
+		"trailing dot":     {host: "muting.example.org.", want: "muting.example.net"},
+		"wildcard":         {host: "*.EXAMPLE.org", want: "*.example.net"},
+		"unicode input":    {host: "shop.bücher.example", want: "shop.xn--bcher-kva.example.net"},
+		"punycode input":   {host: "shop.xn--bcher-kva.example", want: "shop.xn--bcher-kva.example.net"},
+		"unicode output":   {encoding: EncodingUnicode, host: "shop.xn--bcher-kva.example", want: "shop.bücher.example.net"},
+		"unicode wildcard": {encoding: EncodingUnicode, host: "*.bücher.example", want: "*.bücher.example.net"},
+		"punycode output":  {encoding: EncodingPunycode, host: "shop.bücher.example", want: "shop.xn--bcher-kva.example.net"},
+		"unmatched":        {host: "Muting.Example.COM", want: "Muting.Example.COM"},
+		"unnormalizable":   {host: "a_b.example.org", want: "a_b.example.org"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ts, err := newTransformer(TransformOptions{
+				Namespace: testNamespace,
+				Name:      testName,
+				Encoding:  tc.encoding,
+				Client:    fake.NewSimpleClientset(cm).CoreV1(),
+				Metrics:   prometheus.NewRegistry(),
+				Log:       logr.Discard(),
+			})
+			if err != nil {
+				t.Fatalf("newTransformer() error = %v", err)
+			}
+
+			got, err := ts.Transform(context.Background(), TransformRequest{Namespace: testNamespace, Host: tc.host})
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+
+			if got.Host != tc.want {
+				t.Errorf("Transform() host = %v, want %v", got.Host, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransformWildcards(t *testing.T) {
+	tests := map[string]struct {
+		mode      string
+		wildcards string
+		host      string
+		want      string
+		wantErr   error
+	}{
+		"default":             {mode: MatchingLabel, host: "*.example.org", want: "*.example.net"},
+		"rewrite":             {mode: MatchingLabel, wildcards: WildcardRewrite, host: "*.example.org", want: "*.example.net"},
+		"rewrite subdomain":   {mode: MatchingLabel, wildcards: WildcardRewrite, host: "*.a.example.org", want: "*.a.example.net"},
+		"rewrite legacy":      {mode: MatchingLegacy, wildcards: WildcardRewrite, host: "*.example.org", want: "*.example.net"},
+		"ignore":              {mode: MatchingLabel, wildcards: WildcardIgnore, host: "*.example.org", want: "*.example.org"},
+		"ignore legacy":       {mode: MatchingLegacy, wildcards: WildcardIgnore, host: "*.example.org", want: "*.example.org"},
+		"ignore non wildcard": {mode: MatchingLabel, wildcards: WildcardIgnore, host: "a.example.org", want: "a.example.net"},
+		"reject":              {mode: MatchingLabel, wildcards: WildcardReject, host: "*.example.org", wantErr: ErrWildcardRejected},
+		"reject legacy":       {mode: MatchingLegacy, wildcards: WildcardReject, host: "*.example.org", wantErr: ErrWildcardRejected},
+		"reject non wildcard": {mode: MatchingLabel, wildcards: WildcardReject, host: "a.example.org", want: "a.example.net"},
+		"reject unmatched":    {mode: MatchingLabel, wildcards: WildcardReject, host: "*.example.com", want: "*.example.com"},
+	}
+
+	ts := newTestTransforms(t)
+	nl := &namespaceLabels{namespace: testNamespace}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt := []Transform{{From: []string{"example.org"}, To: "example.net", Wildcards: tc.wildcards}}
+
+			got, err := ts.transform(context.Background(), compileRules("", tt, tc.mode), nl, TransformRequest{Host: tc.host})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("transform() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+
+			if got.Host != tc.want {
+				t.Errorf("transform() host = %v, want %v", got.Host, tc.want)
+			}
+		})
+	}
+}
+
+func TestMigrationWarnings(t *testing.T) {
+	tt := []Transform{
+		{Name: "short", From: []string{"ample.org"}, To: "ample.net"},
+		{Name: "long", From: []string{"example.org"}, To: "example.net"},
+		{Name: "sub", From: []string{"a.example.org"}, To: "a.example.net", Matching: MatchingLabel},
+	}
+
+	want := []string{
+		"2 rules without matching now rewrite hosts equal to their suffixes",
+		"rule short suffix ample.org no longer matches hosts under example.org of rule long",
+		"rule short suffix ample.org no longer matches hosts under a.example.org of rule sub",
+	}
+
+	if diff := cmp.Diff(want, migrationWarnings(tt, MatchingLabel)); diff != "" {
+		t.Errorf("migrationWarnings() mismatch (-want +got):\n%v", diff)
+	}
+
+	if got := migrationWarnings(tt, MatchingLegacy); len(got) != 0 {
+		t.Errorf("migrationWarnings() legacy = %v, want none", got)
+	}
+}
+
+func FuzzTransform(f *testing.F) {
+	f.Add("example.org", "example.net", "muting.example.org")
+	f.Add("example.org", "example.net", "example.org")
+	f.Add("example.org", "example.net", "notexample.org")
+	f.Add("a(b", "example.net", "a(b")
+	f.Add("org", "example.net", "a.b.org")
+
+	ts := newTestTransforms(f)
+	ctx := context.Background()
+
+	f.Fuzz(func(t *testing.T, from, to, host string) {
+		// Rules that fail validation are rejected when they are loaded and
+		// never reach the transformer.
+		n, err := Transform{From: []string{from}, To: to}.normalize()
+		if err != nil {
+			return
+		}
+		if err := n.validate(); err != nil {
+			return
+		}
+		tt := []Transform{n}
+		from, to = n.From[0], n.To
+
+		if err := validateHost(host); err != nil {
+			return
+		}
+
+		rs := compileRules("", tt, MatchingLabel)
+		nl := &namespaceLabels{namespace: testNamespace}
+
+		got, err := ts.transform(ctx, rs, nl, TransformRequest{Host: host})
+		if err != nil {
+			if !errors.Is(err, ErrInvalidHost) {
+				t.Fatalf("transform() unexpected error = %v", err)
+			}
+			return
+		}
+
+		if err := validateHost(got.Host); err != nil {
+			t.Errorf("transform() returned invalid host: %v", err)
+		}
+
+		if !strings.HasSuffix(host, from) && got.Host != host {
+			t.Errorf("transform() changed unmatched host: %v => %v", host, got.Host)
+		}
+
+		// Rules whose target ends with their source chain into themselves,
+		// so idempotence is only required of the others.
+		if strings.HasSuffix(to, from) {
+			return
+		}
+
+		again, err := ts.transform(ctx, rs, nl, TransformRequest{Host: got.Host})
+		if err != nil {
+			t.Fatalf("transform() second pass error = %v", err)
+		}
+
+		if again.Host != got.Host {
+			t.Errorf("transform() not idempotent: %v => %v => %v", host, got.Host, again.Host)
+		}
+	})
+}
+
+// BenchmarkTransform compares label matching using the suffix trie and the
+// legacy matching mode with the original linear scan for an Ingress with many
+// hosts against a large rule set.
+func BenchmarkTransform(b *testing.B) {
+	const (
+		rules = 10000
+		hosts = 100
+	)
+
+	tt := make([]Transform, rules)
+	for i := range tt {
+		tt[i] = Transform{
+			From: []string{fmt.Sprintf("tenant%v.corp", i)},
+			To:   fmt.Sprintf("tenant%v.example.net", i),
+		}
+	}
+
+	hs := make([]string, hosts)
+	for i := range hs {
+		hs[i] = fmt.Sprintf("app%v.tenant%v.corp", i, i*rules/hosts)
+	}
+
+	ctx := context.Background()
+	ts := newTestTransforms(b)
+	nl := &namespaceLabels{namespace: testNamespace}
+
+	for _, mode := range []string{MatchingLabel, MatchingLegacy} {
+		rs := compileRules("", tt, mode)
+
+		b.Run(mode, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, h := range hs {
+					if _, err := ts.transform(ctx, rs, nl, TransformRequest{Host: h}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+
+	// Transform loads the rules from the config map informer for every host
+	// as the webhook does.
+	var data strings.Builder
+	for i, t := range tt {
+		fmt.Fprintf(&data, "- name: tenant%v\n  from: [%v]\n  to: %v\n", i, t.From[0], t.To)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The fake client leaves the resource version empty, which disables the
+	// rule cache.
+	cm := newTestConfigMap(data.String())
+	cm.ResourceVersion = "1"
+
+	started := newTestTransforms(b, cm)
+	if err := started.Start(ctx); err != nil {
+		b.Fatalf("Start() error = %v", err)
+	}
+
+	b.Run("transform", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, h := range hs {
+				if _, err := started.Transform(ctx, TransformRequest{Namespace: testNamespace, Host: h}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, h := range hs {
+				linearTransform(tt, h)
+			}
+		}
+	})
+}
+
+// linearTransform is the original nested scan compiling a regular expression
+// for every match.
+func linearTransform(tt []Transform, str string) string {
+	for _, t := range tt {
+		for _, suffix := range t.From {
+			if !strings.HasSuffix(str, suffix) {
+				continue
+			}
+
+			re := regexp.MustCompile(fmt.Sprintf(".%v$", suffix))
+			return re.ReplaceAllString(str, fmt.Sprintf("$1.%v", t.To))
+		}
+	}
+
+	return str
+}
+
+func TestTransformRuleMatchesMetric(t *testing.T) {
+	ts := newTestTransforms(t, newTestConfigMap(`
+- name: example
+  from: [example.org]
+  to: example.net
+- name: idle
+  from: [example.com]
+  to: example.net
+`))
+
+	if _, err := ts.Transform(context.Background(), TransformRequest{Namespace: testNamespace, Host: "a.example.org"}); err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if got := testutil.CollectAndCount(ts.Metrics.RuleMatches); got != 2 {
+		t.Errorf("rule match series = %v, want 2", got)
+	}
+
+	for rule, want := range map[string]float64{"example": 1, "idle": 0} {
+		if got := testutil.ToFloat64(ts.Metrics.RuleMatches.WithLabelValues(rule)); got != want {
+			t.Errorf("rule %v matches = %v, want %v", rule, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// UpdateAlways rewrites every matching host on update.
+	UpdateAlways = "always"
+	// UpdateOnCreate only rewrites hosts when the object is created.
+	UpdateOnCreate = "on-create"
+	// UpdateOnChange rewrites hosts on update that are not in the previous
+	// version of the object.
+	UpdateOnChange = "on-change"
+)
+
+var ErrUpdateUnknown = errors.New("unknown update policy")
+
+func validateUpdate(policy string) error {
+	switch policy {
+	case "", UpdateAlways, UpdateOnCreate, UpdateOnChange:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %v", ErrUpdateUnknown, policy)
+}
+
+// update returns the effective update policy of a rule.
+func (t Transform) update() string {
+	if t.Update != "" {
+		return t.Update
+	}
+
+	return UpdateOnChange
+}
+
+// previousHosts holds the hosts of the previous version of an updated object.
+// It is nil when the object is being created.
+type previousHosts map[string]bool
+
+// allows reports whether the rule may rewrite the host.
+func (p previousHosts) allows(t Transform, host string) bool {
+	if p == nil {
+		return true
+	}
+
+	switch t.update() {
+	case UpdateAlways:
+		return true
+	case UpdateOnCreate:
+		return false
+	}
+
+	return !p[host]
+}
+
+// previousIngressHosts returns the rule, TLS and annotation hosts of the
+// previous version of an Ingress.
+func previousIngressHosts(raw []byte, annotations []string) (previousHosts, error) {
+	p := make(previousHosts)
+	if len(raw) == 0 {
+		return p, nil
+	}
+
+	var ing networkingv1.Ingress
+	if err := json.Unmarshal(raw, &ing); err != nil {
+		return nil, fmt.Errorf("unable to decode old object: %w", err)
+	}
+
+	for _, rule := range ing.Spec.Rules {
+		p[rule.Host] = true
+	}
+
+	for _, tls := range ing.Spec.TLS {
+		for _, h := range tls.Hosts {
+			p[h] = true
+		}
+	}
+
+	for _, key := range annotations {
+		for _, h := range strings.Split(ing.Annotations[key], ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				p[h] = true
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// previousCertificateHosts returns the DNS names and common name of the
+// previous version of a Certificate.
+func previousCertificateHosts(raw []byte) (previousHosts, error) {
+	p := make(previousHosts)
+	if len(raw) == 0 {
+		return p, nil
+	}
+
+	var u unstructured.Unstructured
+	if err := u.UnmarshalJSON(raw); err != nil {
+		return nil, fmt.Errorf("unable to decode old object: %w", err)
+	}
+
+	names, _, _ := unstructured.NestedStringSlice(u.Object, "spec", "dnsNames")
+	for _, name := range names {
+		p[name] = true
+	}
+
+	if cn, _, _ := unstructured.NestedString(u.Object, "spec", "commonName"); cn != "" {
+		p[cn] = true
+	}
+
+	return p, nil
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMutatorUpdate(t *testing.T) {
+	tests := map[string]struct {
+		update    string
+		operation kwhmodel.AdmissionReviewOp
+		dryRun    bool
+		old       string
+		host      string
+		want      string
+	}{
+		"create": {
+			operation: kwhmodel.OperationCreate,
+			host:      "a.example.org",
+			want:      "a.example.net",
+		},
+		"unchanged": {
+			operation: kwhmodel.OperationUpdate,
+			old:       "a.example.org",
+			host:      "a.example.org",
+			want:      "a.example.org",
+		},
+		"changed": {
+			operation: kwhmodel.OperationUpdate,
+			old:       "a.example.net",
+			host:      "b.example.org",
+			want:      "b.example.net",
+		},
+		"always": {
+			update:    UpdateAlways,
+			operation: kwhmodel.OperationUpdate,
+			old:       "a.example.org",
+			host:      "a.example.org",
+			want:      "a.example.net",
+		},
+		"on create": {
+			update:    UpdateOnCreate,
+			operation: kwhmodel.OperationUpdate,
+			old:       "a.example.net",
+			host:      "b.example.org",
+			want:      "b.example.org",
+		},
+		"on create created": {
+			update:    UpdateOnCreate,
+			operation: kwhmodel.OperationCreate,
+			host:      "a.example.org",
+			want:      "a.example.net",
+		},
+		"dry run": {
+			operation: kwhmodel.OperationCreate,
+			dryRun:    true,
+			host:      "a.example.org",
+			want:      "a.example.net",
+		},
+	}
+
+	newIngress := func(host string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "muting"},
+			Spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{{Host: host}},
+			},
+		}
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules := "- name: example\n  from: [example.org]\n  to: example.net\n"
+			if tc.update != "" {
+				rules += "  update: " + tc.update + "\n"
+			}
+
+			m, err := newWebhookMetrics(prometheus.NewRegistry())
+			if err != nil {
+				t.Fatalf("newWebhookMetrics() error = %v", err)
+			}
+
+			ts := newTestTransforms(t, newTestConfigMap(rules))
+
+			mutate := mutatorFunc(WebhookOptions{
+				Transformer: ts,
+				Events:      noopEventRecorder{},
+				Collisions:  noopCollisionChecker{},
+				Log:         logr.Discard(),
+			}, m)
+
+			ar := &kwhmodel.AdmissionReview{
+				Namespace: "default",
+				Name:      "muting",
+				Operation: tc.operation,
+				DryRun:    tc.dryRun,
+			}
+			if tc.old != "" {
+				raw, err := json.Marshal(newIngress(tc.old))
+				if err != nil {
+					t.Fatalf("unable to marshal old object: %v", err)
+				}
+				ar.OldObjectRaw = raw
+			}
+
+			ing := newIngress(tc.host)
+			if _, err := mutate(context.Background(), ar, ing); err != nil {
+				t.Fatalf("mutate() error = %v", err)
+			}
+
+			if got := ing.Spec.Rules[0].Host; got != tc.want {
+				t.Errorf("host = %v, want %v", got, tc.want)
+			}
+
+			// Hosts left alone by the update policy are skipped rather than
+			// counted as matches of the rule, and dry runs are not counted.
+			var want uint64
+			if tc.want != tc.host && !tc.dryRun {
+				want = 1
+			}
+
+			tt, err := ts.read(context.Background())
+			if err != nil {
+				t.Fatalf("read() error = %v", err)
+			}
+
+			if got := ts.hitCount(tt[0]); got != want {
+				t.Errorf("hits = %v, want %v", got, want)
+			}
+
+			if got := testutil.ToFloat64(ts.Metrics.RuleMatches.WithLabelValues("example")); got != float64(want) {
+				t.Errorf("rule matches = %v, want %v", got, want)
+			}
+
+			if tc.dryRun {
+				if got := testutil.CollectAndCount(ts.Metrics.Transforms); got != 0 {
+					t.Errorf("transform series = %v, want 0", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	kwhvalidating "github.com/slok/kubewebhook/v2/pkg/webhook/validating"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValidateRules checks rules as they would be loaded with the default matching
+// mode and returns warnings about rules that match differently than in legacy
+// mode. Unknown fields are rejected so that a misspelt field fails validation
+// instead of being silently ignored.
+func ValidateRules(data, mode string) ([]string, error) {
+	if err := validateMatching(mode); err != nil {
+		return nil, err
+	}
+
+	tt, err := parseTransforms(data, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrationWarnings(tt, mode), nil
+}
+
+// validateConfigMap checks that the rules of the transforms config map are
+// valid. Other keys are allowed, such as notes kept alongside the rules, but
+// are reported as they are never read.
+func validateConfigMap(cm *corev1.ConfigMap, mode string) ([]string, error) {
+	var unknown []string
+	for k := range cm.Data {
+		if k != transformsKey {
+			unknown = append(unknown, k)
+		}
+	}
+	for k := range cm.BinaryData {
+		unknown = append(unknown, k)
+	}
+
+	warnings, err := ValidateRules(cm.Data[transformsKey], mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		warnings = append(warnings, fmt.Sprintf("config map keys %v are ignored, only %v is read", unknown, transformsKey))
+	}
+
+	return warnings, nil
+}
+
+func validatorFunc(o WebhookOptions) func(ctx context.Context, ar *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhvalidating.ValidatorResult, error) {
+	return func(ctx context.Context, ar *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhvalidating.ValidatorResult, error) {
+		_, span := otel.Tracer(name).Start(ctx, "validatorFunc",
+			trace.WithAttributes(admissionAttributes(ar)...),
+		)
+		defer span.End()
+
+		log := o.Log.WithValues(
+			"uid", ar.ID,
+			"traceID", span.SpanContext().TraceID(),
+			"namespace", ar.Namespace,
+			"name", ar.Name,
+			"operation", ar.Operation,
+		)
+
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || cm.Namespace != o.Namespace || cm.Name != o.Name {
+			return &kwhvalidating.ValidatorResult{Valid: true}, nil
+		}
+
+		warnings, err := validateConfigMap(cm, o.Matching)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			log.Info("Rejected invalid transforms.", "error", err.Error())
+			return &kwhvalidating.ValidatorResult{
+				Valid:   false,
+				Message: err.Error(),
+			}, nil
+		}
+
+		log.V(1).Info("Validated transforms.", "warnings", len(warnings))
+
+		return &kwhvalidating.ValidatorResult{
+			Valid:    true,
+			Warnings: warnings,
+		}, nil
+	}
+}
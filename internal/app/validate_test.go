@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidateConfigMap(t *testing.T) {
+	tests := map[string]struct {
+		cm           *corev1.ConfigMap
+		wantWarnings int
+		wantErr      bool
+		is           error
+	}{
+		"valid": {
+			cm: newTestConfigMap("- from: [example.org]\n  to: example.net\n"),
+		},
+		"empty": {
+			cm: &corev1.ConfigMap{},
+		},
+		"unknown key": {
+			cm: &corev1.ConfigMap{Data: map[string]string{
+				"transforms": "- from: [example.org]\n  to: example.net\n",
+				"notes":      "owned by the platform team",
+			}},
+			wantWarnings: 1,
+		},
+		"unknown field": {
+			cm:      newTestConfigMap("- form: [example.org]\n  to: example.net\n"),
+			wantErr: true,
+		},
+		"invalid host": {
+			cm:      newTestConfigMap("- from: [example.org]\n  to: example_net\n"),
+			wantErr: true,
+			is:      ErrInvalidHost,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			warnings, err := validateConfigMap(tc.cm, MatchingLegacy)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("validateConfigMap() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if len(warnings) != tc.wantWarnings {
+				t.Errorf("validateConfigMap() warnings = %v, want %v", warnings, tc.wantWarnings)
+			}
+
+			if tc.is != nil && !errors.Is(err, tc.is) {
+				t.Errorf("validateConfigMap() error = %v, want %v", err, tc.is)
+			}
+		})
+	}
+}
+
+func TestValidatingWebhook(t *testing.T) {
+	wh, err := newWebhook(context.Background(), WebhookOptions{
+		Namespace:   testNamespace,
+		Name:        testName,
+		Transformer: newTestTransforms(t),
+		Metrics:     prometheus.NewRegistry(),
+		Log:         logr.Discard(),
+	})
+	if err != nil {
+		t.Fatalf("unable to create webhook: %v", err)
+	}
+
+	invalid := newTestConfigMap("- from: {")
+	other := newTestConfigMap("- from: {")
+	other.Name = "other"
+
+	tests := map[string]struct {
+		cm   *corev1.ConfigMap
+		want bool
+	}{
+		"valid":            {cm: newTestConfigMap("- from: [example.org]\n  to: example.net\n"), want: true},
+		"invalid":          {cm: invalid, want: false},
+		"other config map": {cm: other, want: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw, err := json.Marshal(tc.cm)
+			if err != nil {
+				t.Fatalf("unable to marshal config map: %v", err)
+			}
+
+			body, err := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:       "b8f1c3d2-6a4e-4f0b-9c7d-2e5a1f3b4c6d",
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+					Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+					Namespace: tc.cm.Namespace,
+					Name:      tc.cm.Name,
+					Operation: admissionv1.Update,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+			if err != nil {
+				t.Fatalf("unable to marshal review: %v", err)
+			}
+
+			rec := httptest.NewRecorder()
+			wh.ValidatingHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, validatePath, bytes.NewReader(body)))
+
+			var ar admissionv1.AdmissionReview
+			if err := json.Unmarshal(rec.Body.Bytes(), &ar); err != nil {
+				t.Fatalf("unable to unmarshal response: %v: %v", err, rec.Body.String())
+			}
+
+			if ar.Response.Allowed != tc.want {
+				t.Errorf("allowed = %v, want %v: %v", ar.Response.Allowed, tc.want, ar.Response.Result)
+			}
+		})
+	}
+}
+
+func TestValidateRulesMatching(t *testing.T) {
+	rules := "- from: [ample.org]\n  to: short.net\n- from: [example.org]\n  to: long.net\n"
+
+	tests := map[string]struct {
+		mode    string
+		want    int
+		wantErr bool
+	}{
+		"label":   {mode: MatchingLabel, want: 2},
+		"legacy":  {mode: MatchingLegacy},
+		"unknown": {mode: "prefix", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ValidateRules(rules, tc.mode)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ValidateRules() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if len(got) != tc.want {
+				t.Errorf("ValidateRules() warnings = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/go-logr/logr"
+	"github.com/mikelorant/muting2/internal/logging"
 	"github.com/prometheus/client_golang/prometheus"
 	kwhhttp "github.com/slok/kubewebhook/v2/pkg/http"
 	kwhprometheus "github.com/slok/kubewebhook/v2/pkg/metrics/prometheus"
@@ -12,24 +14,88 @@ import (
 	"github.com/slok/kubewebhook/v2/pkg/webhook"
 	kwhwebhook "github.com/slok/kubewebhook/v2/pkg/webhook"
 	kwhmutating "github.com/slok/kubewebhook/v2/pkg/webhook/mutating"
+	kwhvalidating "github.com/slok/kubewebhook/v2/pkg/webhook/validating"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 type Transformer interface {
-	Transform(context.Context, string) (string, error)
+	Transform(ctx context.Context, req TransformRequest) (TransformResult, error)
+}
+
+// classFilter is implemented by transformers that report whether any rule is
+// restricted to ingress classes so that the default class is only resolved
+// when a rule needs it.
+type classFilter interface {
+	UsesIngressClasses() bool
+}
+
+type EventRecorder interface {
+	Rewritten(ar *kwhmodel.AdmissionReview, changes []HostChange)
+	Failed(ar *kwhmodel.AdmissionReview, err error)
+}
+
+type CollisionChecker interface {
+	Check(ctx context.Context, namespace string, hosts []string) ([]string, error)
 }
 
 type Webhook struct {
-	Webhook webhook.Webhook
+	Webhook    webhook.Webhook
+	Validating webhook.Webhook
+	Options    WebhookOptions
 }
 
-func newWebhook(ctx context.Context, t Transformer, r prometheus.Registerer) (*Webhook, error) {
+// WebhookOptions configures the mutating webhook for Ingresses, and
+// Certificates when enabled, and the validating webhook for the transforms
+// config map at Namespace and Name.
+type WebhookOptions struct {
+	Namespace    string
+	Name         string
+	Matching     string
+	Transformer  Transformer
+	Annotations  []string
+	Classes      ClassOptions
+	Shadow       ShadowOptions
+	Certificates bool
+	Events       EventRecorder
+	Collisions   CollisionChecker
+	Metrics      prometheus.Registerer
+	Log          logr.Logger
+}
+
+func newWebhook(ctx context.Context, o WebhookOptions) (*Webhook, error) {
+	if o.Events == nil {
+		o.Events = noopEventRecorder{}
+	}
+
+	if o.Collisions == nil {
+		o.Collisions = noopCollisionChecker{}
+	}
+
+	m, err := newWebhookMetrics(o.Metrics)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create metrics: %w", err)
+	}
+
+	var obj metav1.Object = &networkingv1.Ingress{}
+	if o.Certificates {
+		// Objects are decoded by kind, falling back to unstructured objects
+		// for Certificates.
+		obj = nil
+	}
+
 	whcfg := kwhmutating.WebhookConfig{
 		ID:      "muting",
-		Obj:     &networkingv1.Ingress{},
-		Mutator: kwhmutating.MutatorFunc(mutatorFunc(t)),
+		Obj:     obj,
+		Mutator: kwhmutating.MutatorFunc(mutatorFunc(o, m)),
+		Logger:  logging.Kubewebhook(o.Log),
 	}
 
 	wh, err := kwhmutating.NewWebhook(whcfg)
@@ -37,39 +103,267 @@ func newWebhook(ctx context.Context, t Transformer, r prometheus.Registerer) (*W
 		return nil, fmt.Errorf("unable to create webhook: %w", err)
 	}
 
-	rec, err := kwhprometheus.NewRecorder(kwhprometheus.RecorderConfig{Registry: r})
+	vwh, err := kwhvalidating.NewWebhook(kwhvalidating.WebhookConfig{
+		ID:        "muting-rules",
+		Obj:       &corev1.ConfigMap{},
+		Validator: kwhvalidating.ValidatorFunc(validatorFunc(o)),
+		Logger:    logging.Kubewebhook(o.Log),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create validating webhook: %w", err)
+	}
+
+	rec, err := kwhprometheus.NewRecorder(kwhprometheus.RecorderConfig{Registry: o.Metrics})
 	if err != nil {
 		return nil, fmt.Errorf("unable to create recorder: %w", err)
 	}
 
 	return &Webhook{
-		Webhook: kwhwebhook.NewMeasuredWebhook(rec, wh),
+		Webhook:    kwhwebhook.NewMeasuredWebhook(rec, wh),
+		Validating: kwhwebhook.NewMeasuredWebhook(rec, vwh),
+		Options:    o,
 	}, nil
 }
 
 func (w *Webhook) Handler() http.Handler {
-	return kwhhttp.MustHandlerFor(kwhhttp.HandlerConfig{Webhook: w.Webhook})
+	return kwhhttp.MustHandlerFor(kwhhttp.HandlerConfig{
+		Webhook: w.Webhook,
+		Logger:  logging.Kubewebhook(w.Options.Log),
+	})
+}
+
+func (w *Webhook) ValidatingHandler() http.Handler {
+	return kwhhttp.MustHandlerFor(kwhhttp.HandlerConfig{
+		Webhook: w.Validating,
+		Logger:  logging.Kubewebhook(w.Options.Log),
+	})
 }
 
-func mutatorFunc(t Transformer) func(ctx context.Context, ar *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhmutating.MutatorResult, error) {
+func mutatorFunc(o WebhookOptions, m *WebhookMetrics) func(ctx context.Context, ar *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhmutating.MutatorResult, error) {
 	return func(ctx context.Context, ar *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhmutating.MutatorResult, error) {
-		ctx, span := otel.Tracer(name).Start(ctx, "mutatorFunc")
+		ctx, span := otel.Tracer(name).Start(ctx, "mutatorFunc",
+			trace.WithAttributes(admissionAttributes(ar)...),
+		)
 		defer span.End()
 
+		log := o.Log.WithValues(
+			"uid", ar.ID,
+			"traceID", span.SpanContext().TraceID(),
+			"namespace", ar.Namespace,
+			"name", ar.Name,
+			"operation", ar.Operation,
+			"dryRun", ar.DryRun,
+		)
+
+		if u, ok := obj.(*unstructured.Unstructured); ok && o.Certificates && isCertificate(u) {
+			return mutateCertificate(ctx, o, m, ar, u, log)
+		}
+
 		ing, ok := obj.(*networkingv1.Ingress)
 		if !ok {
 			return &kwhmutating.MutatorResult{}, nil
 		}
+		orig := ing.DeepCopy()
 
-		for idx, rule := range ing.Spec.Rules {
-			host, err := t.Transform(ctx, rule.Host)
+		fallback := len(o.Classes.Classes) != 0
+		if cf, ok := o.Transformer.(classFilter); !ok || cf.UsesIngressClasses() {
+			fallback = true
+		}
+
+		class, err := o.Classes.resolve(ing, fallback)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			o.Events.Failed(ar, err)
+			log.Error(err, "Unable to resolve ingress class.")
+			return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to resolve ingress class: %w", err)
+		}
+
+		span.SetAttributes(attribute.String("muting.ingress.class", class))
+
+		if !o.Classes.allows(class) {
+			if !ar.DryRun {
+				m.Skipped.WithLabelValues(ar.Namespace, class).Inc()
+			}
+			log.Info("Skipped admission for ingress class.", "class", class)
+			return &kwhmutating.MutatorResult{}, nil
+		}
+
+		shadow, err := o.Shadow.enabled(ctx, ar.Namespace)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			o.Events.Failed(ar, err)
+			log.Error(err, "Unable to determine shadow mode.")
+			return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to determine shadow mode: %w", err)
+		}
+
+		var previous previousHosts
+		if ar.Operation == kwhmodel.OperationUpdate {
+			previous, err = previousIngressHosts(ar.OldObjectRaw, o.Annotations)
 			if err != nil {
-				return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to transform host: %w", err)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				o.Events.Failed(ar, err)
+				log.Error(err, "Unable to read previous hosts.")
+				return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to read previous hosts: %w", err)
 			}
-			rule.Host = host
-			ing.Spec.Rules[idx] = rule
 		}
 
-		return &kwhmutating.MutatorResult{MutatedObject: ing}, nil
+		changes, err := transformIngress(ctx, o.Transformer, ar.Namespace, ing, ingressOptions{
+			Annotations: o.Annotations,
+			Class:       class,
+			Shadow:      shadow,
+			Previous:    previous,
+			DryRun:      ar.DryRun,
+		})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			o.Events.Failed(ar, err)
+			log.Error(err, "Unable to transform host.")
+			return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to transform host: %w", err)
+		}
+
+		changes, shadowed := splitChanges(changes)
+
+		span.SetAttributes(
+			attribute.Int("muting.hosts.rewritten", len(changes)),
+			attribute.Int("muting.hosts.shadowed", len(shadowed)),
+		)
+
+		var hosts []string
+		for _, c := range changes {
+			hosts = append(hosts, c.To)
+		}
+
+		warnings, err := o.Collisions.Check(ctx, ar.Namespace, hosts)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			o.Events.Failed(ar, err)
+			log.Error(err, "Rejected host collision.", "rewritten", changes)
+			return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to admit hosts: %w", err)
+		}
+		for _, w := range warnings {
+			log.Info("Detected host collision.", "collision", w)
+		}
+
+		for _, c := range shadowed {
+			if !ar.DryRun {
+				m.Shadowed.WithLabelValues(c.Rule, ar.Namespace).Inc()
+			}
+			warnings = append(warnings, fmt.Sprintf("shadow mode: would rewrite %v", c))
+		}
+		annotateShadow(ing, shadowed)
+
+		o.Events.Rewritten(ar, changes)
+		log.Info("Reviewed admission.", "rewritten", changes, "shadowed", shadowed)
+
+		return mutatorResult(ar, orig, ing, warnings), nil
+	}
+}
+
+// mutateCertificate rewrites the hosts of a Certificate. Host collisions are
+// only checked for Ingresses and events are only recorded for Ingresses.
+func mutateCertificate(ctx context.Context, o WebhookOptions, m *WebhookMetrics, ar *kwhmodel.AdmissionReview, u *unstructured.Unstructured, log logr.Logger) (*kwhmutating.MutatorResult, error) {
+	ctx, span := otel.Tracer(name).Start(ctx, "mutateCertificate")
+	defer span.End()
+
+	orig := u.DeepCopy()
+
+	shadow, err := o.Shadow.enabled(ctx, ar.Namespace)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Error(err, "Unable to determine shadow mode.")
+		return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to determine shadow mode: %w", err)
+	}
+
+	var previous previousHosts
+	if ar.Operation == kwhmodel.OperationUpdate {
+		previous, err = previousCertificateHosts(ar.OldObjectRaw)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			log.Error(err, "Unable to read previous hosts.")
+			return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to read previous hosts: %w", err)
+		}
+	}
+
+	changes, err := transformCertificate(ctx, o.Transformer, ar.Namespace, u, ingressOptions{
+		Shadow:   shadow,
+		Previous: previous,
+		DryRun:   ar.DryRun,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Error(err, "Unable to transform certificate.")
+		return &kwhmutating.MutatorResult{}, fmt.Errorf("unable to transform certificate: %w", err)
+	}
+
+	changes, shadowed := splitChanges(changes)
+
+	span.SetAttributes(
+		attribute.Int("muting.hosts.rewritten", len(changes)),
+		attribute.Int("muting.hosts.shadowed", len(shadowed)),
+	)
+
+	var warnings []string
+	for _, c := range shadowed {
+		if !ar.DryRun {
+			m.Shadowed.WithLabelValues(c.Rule, ar.Namespace).Inc()
+		}
+		warnings = append(warnings, fmt.Sprintf("shadow mode: would rewrite %v", c))
+	}
+	annotateShadow(u, shadowed)
+
+	log.Info("Reviewed certificate admission.", "rewritten", changes, "shadowed", shadowed)
+
+	return mutatorResult(ar, orig, u, warnings), nil
+}
+
+// mutatorResult returns the object as received when it is unchanged so that
+// the patch is empty rather than normalizing the encoding of unrelated
+// fields such as a missing creation timestamp.
+func mutatorResult(ar *kwhmodel.AdmissionReview, orig, obj metav1.Object, warnings []string) *kwhmutating.MutatorResult {
+	res := &kwhmutating.MutatorResult{
+		MutatedObject: obj,
+		Warnings:      warnings,
+	}
+
+	if !equality.Semantic.DeepEqual(orig, obj) {
+		return res
+	}
+
+	var u unstructured.Unstructured
+	if err := u.UnmarshalJSON(ar.NewObjectRaw); err == nil {
+		res.MutatedObject = &u
 	}
+
+	return res
+}
+
+func admissionAttributes(ar *kwhmodel.AdmissionReview) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("admission.uid", ar.ID),
+		attribute.String("admission.namespace", ar.Namespace),
+		attribute.String("admission.name", ar.Name),
+		attribute.String("admission.operation", string(ar.Operation)),
+		attribute.String("admission.user", ar.UserInfo.Username),
+		attribute.Bool("admission.dry_run", ar.DryRun),
+	}
+}
+
+type noopEventRecorder struct{}
+
+func (noopEventRecorder) Rewritten(*kwhmodel.AdmissionReview, []HostChange) {}
+
+func (noopEventRecorder) Failed(*kwhmodel.AdmissionReview, error) {}
+
+type noopCollisionChecker struct{}
+
+func (noopCollisionChecker) Check(context.Context, string, []string) ([]string, error) {
+	return nil, nil
 }
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const fixtures = "../../test"
+
+// TestWebhookGolden runs every admission review fixture through the webhook
+// handler and compares the returned JSON patch with the stored golden file.
+func TestWebhookGolden(t *testing.T) {
+	runGolden(t, fixtures, WebhookOptions{})
+}
+
+// TestWebhookGoldenCertificates runs the certificate fixtures through the
+// webhook handler with Certificates enabled, and the Ingress fixtures too as
+// the handler then decodes every object dynamically.
+func TestWebhookGoldenCertificates(t *testing.T) {
+	t.Run("certificates", func(t *testing.T) {
+		runGolden(t, filepath.Join(fixtures, "certificates"), WebhookOptions{Certificates: true})
+	})
+
+	t.Run("ingresses", func(t *testing.T) {
+		runGolden(t, fixtures, WebhookOptions{Certificates: true})
+	})
+}
+
+// runGolden compares the patch of every fixture in the directory with its
+// golden file using a webhook with the options and the fixture rules.
+func runGolden(t *testing.T, dir string, o WebhookOptions) {
+	t.Helper()
+
+	rules, err := os.ReadFile(filepath.Join(fixtures, "transforms.yaml"))
+	if err != nil {
+		t.Fatalf("unable to read rules: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("unable to find fixtures: %v", err)
+	}
+
+	for _, file := range files {
+		file := file
+
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			o := o
+			o.Transformer = newTestTransforms(t, newTestConfigMap(string(rules)))
+			o.Metrics = prometheus.NewRegistry()
+			o.Log = logr.Discard()
+
+			wh, err := newWebhook(context.Background(), o)
+			if err != nil {
+				t.Fatalf("unable to create webhook: %v", err)
+			}
+
+			got := review(t, wh.Handler(), file)
+
+			golden := strings.TrimSuffix(file, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("unable to update golden file: %v", err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unable to read golden file: %v", err)
+			}
+
+			if diff := cmp.Diff(string(want), string(got)); diff != "" {
+				t.Errorf("patch mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestTransformIngress(t *testing.T) {
+	tests := map[string]struct {
+		wildcards string
+		want      []string
+		changes   int
+		wantErr   error
+	}{
+		"rewrite": {
+			wildcards: WildcardRewrite,
+			want:      []string{"*.example.net", "*.example.net", "muting.example.net"},
+			changes:   2,
+		},
+		"ignore": {
+			wildcards: WildcardIgnore,
+			want:      []string{"*.example.org", "*.example.org", "muting.example.net"},
+			changes:   1,
+		},
+		"reject": {
+			wildcards: WildcardReject,
+			wantErr:   ErrWildcardRejected,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestTransforms(t, newTestConfigMap(fmt.Sprintf(`
+- from: [example.org]
+  to: example.net
+  wildcards: %v
+`, tc.wildcards)))
+
+			ing := &networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "*.example.org"}},
+					TLS: []networkingv1.IngressTLS{{
+						Hosts: []string{"*.example.org", "muting.example.org"},
+					}},
+				},
+			}
+
+			changes, err := transformIngress(context.Background(), ts, testNamespace, ing, ingressOptions{})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("transformIngress() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+
+			got := []string{ing.Spec.Rules[0].Host}
+			got = append(got, ing.Spec.TLS[0].Hosts...)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("transformIngress() hosts mismatch (-want +got):\n%v", diff)
+			}
+
+			if len(changes) != tc.changes {
+				t.Errorf("transformIngress() changes = %v, want %v", changes, tc.changes)
+			}
+		})
+	}
+}
+
+func review(t *testing.T, h http.Handler, file string) []byte {
+	t.Helper()
+
+	body, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read fixture: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %v: %v", rec.Code, rec.Body.String())
+	}
+
+	var ar admissionv1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &ar); err != nil {
+		t.Fatalf("unable to unmarshal response: %v", err)
+	}
+
+	patch := ar.Response.Patch
+	if len(patch) == 0 {
+		patch = []byte("[]")
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, patch, "", "  "); err != nil {
+		t.Fatalf("unable to indent patch: %v", err)
+	}
+	out.WriteString("\n")
+
+	return out.Bytes()
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// WildcardRewrite rewrites the suffix of a wildcard host and keeps the
+	// wildcard label.
+	WildcardRewrite = "rewrite"
+	// WildcardIgnore leaves wildcard hosts unchanged.
+	WildcardIgnore = "ignore"
+	// WildcardReject denies admission of wildcard hosts.
+	WildcardReject = "reject"
+)
+
+var (
+	ErrWildcardUnknown  = errors.New("unknown wildcard handling")
+	ErrWildcardRejected = errors.New("wildcard host rejected")
+)
+
+func validateWildcards(mode string) error {
+	switch mode {
+	case "", WildcardRewrite, WildcardIgnore, WildcardReject:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %v", ErrWildcardUnknown, mode)
+}
+
+// wildcards returns the effective wildcard handling of a rule.
+func (t Transform) wildcards() string {
+	if t.Wildcards != "" {
+		return t.Wildcards
+	}
+
+	return WildcardRewrite
+}
+
+func isWildcard(host string) bool {
+	return strings.HasPrefix(host, "*.")
+}
+
+// validateWildcardHost checks that a host is a valid RFC 1123 subdomain with
+// a leading wildcard label.
+func validateWildcardHost(host string) error {
+	if errs := validation.IsWildcardDNS1123Subdomain(host); len(errs) != 0 {
+		return fmt.Errorf("%w: %q: %v", ErrInvalidHost, host, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
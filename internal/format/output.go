@@ -0,0 +1,62 @@
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	Table = "table"
+	JSON  = "json"
+)
+
+var ErrFormatUnknown = errors.New("unknown output format")
+
+// Tabular is a value that can be written as a table.
+type Tabular interface {
+	Header() []string
+	Rows() [][]string
+}
+
+// Write writes the value as a table or as indented JSON.
+func Write(w io.Writer, format string, v Tabular) error {
+	switch format {
+	case Table:
+		return WriteTable(w, v.Header(), v.Rows())
+	case JSON:
+		return WriteJSON(w, v)
+	}
+
+	return fmt.Errorf("%w: %v", ErrFormatUnknown, format)
+}
+
+// WriteTable writes tab aligned columns with an upper case header.
+func WriteTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write table: %w", err)
+	}
+
+	return nil
+}
+
+func WriteJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("unable to write json: %w", err)
+	}
+
+	return nil
+}
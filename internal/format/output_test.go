@@ -0,0 +1,50 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type rows [][]string
+
+func (r rows) Header() []string { return []string{"name", "to"} }
+func (r rows) Rows() [][]string { return r }
+
+func TestWrite(t *testing.T) {
+	v := rows{{"example", "example.net"}, {"0", "b.example.net"}}
+
+	tests := map[string]struct {
+		format  string
+		want    string
+		wantErr error
+	}{
+		"table": {
+			format: Table,
+			want:   "NAME     TO\nexample  example.net\n0        b.example.net\n",
+		},
+		"json": {
+			format: JSON,
+			want:   "[\n  [\n    \"example\",\n    \"example.net\"\n  ],\n  [\n    \"0\",\n    \"b.example.net\"\n  ]\n]\n",
+		},
+		"unknown": {
+			format:  "yaml",
+			wantErr: ErrFormatUnknown,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			err := Write(&buf, tc.format, v)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if got := buf.String(); got != tc.want {
+				t.Errorf("Write() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
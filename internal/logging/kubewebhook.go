@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	kwhlog "github.com/slok/kubewebhook/v2/pkg/log"
+)
+
+type kubewebhook struct {
+	log logr.Logger
+}
+
+// Kubewebhook adapts a logr logger to the logger interface used by the
+// kubewebhook library.
+func Kubewebhook(l logr.Logger) kwhlog.Logger {
+	return kubewebhook{log: l}
+}
+
+func (k kubewebhook) Infof(format string, args ...interface{}) {
+	k.log.Info(fmt.Sprintf(format, args...))
+}
+
+func (k kubewebhook) Warningf(format string, args ...interface{}) {
+	k.log.Info(fmt.Sprintf(format, args...), "warning", true)
+}
+
+func (k kubewebhook) Errorf(format string, args ...interface{}) {
+	k.log.Error(nil, fmt.Sprintf(format, args...))
+}
+
+func (k kubewebhook) Debugf(format string, args ...interface{}) {
+	k.log.V(1).Info(fmt.Sprintf(format, args...))
+}
+
+func (k kubewebhook) WithValues(values map[string]interface{}) kwhlog.Logger {
+	return kubewebhook{log: k.log.WithValues(keysAndValues(values)...)}
+}
+
+func (k kubewebhook) WithCtxValues(ctx context.Context) kwhlog.Logger {
+	return k.WithValues(kwhlog.ValuesFromCtx(ctx))
+}
+
+func (k kubewebhook) SetValuesOnCtx(parent context.Context, values map[string]interface{}) context.Context {
+	return kwhlog.CtxWithValues(parent, values)
+}
+
+func keysAndValues(values map[string]interface{}) []interface{} {
+	kvs := make([]interface{}, 0, len(values)*2)
+	for k, v := range values {
+		kvs = append(kvs, k, v)
+	}
+
+	return kvs
+}
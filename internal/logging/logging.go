@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+)
+
+type Options struct {
+	Level  string
+	Format string
+	Writer io.Writer
+}
+
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+
+	LevelError = "error"
+	LevelInfo  = "info"
+	LevelDebug = "debug"
+	LevelTrace = "trace"
+)
+
+var (
+	ErrLevelUnknown  = errors.New("unknown log level")
+	ErrFormatUnknown = errors.New("unknown log format")
+)
+
+var levels = map[string]int{
+	LevelError: -1,
+	LevelInfo:  0,
+	LevelDebug: 1,
+	LevelTrace: 2,
+}
+
+func New(o Options) (logr.Logger, error) {
+	if o.Writer == nil {
+		o.Writer = os.Stdout
+	}
+
+	v, ok := levels[strings.ToLower(o.Level)]
+	if !ok {
+		return logr.Discard(), fmt.Errorf("%w: %v", ErrLevelUnknown, o.Level)
+	}
+
+	opts := funcr.Options{
+		LogTimestamp: true,
+		Verbosity:    v,
+	}
+
+	switch strings.ToLower(o.Format) {
+	case FormatJSON:
+		return funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(o.Writer, obj)
+		}, opts), nil
+	case FormatConsole:
+		return funcr.New(func(prefix, args string) {
+			if prefix != "" {
+				fmt.Fprintln(o.Writer, prefix, args)
+				return
+			}
+			fmt.Fprintln(o.Writer, args)
+		}, opts), nil
+	}
+
+	return logr.Discard(), fmt.Errorf("%w: %v", ErrFormatUnknown, o.Format)
+}
@@ -0,0 +1,116 @@
+package suffix
+
+import (
+	"sort"
+	"strings"
+)
+
+// Trie matches hostnames against suffixes on DNS label boundaries. Suffixes
+// are stored by their labels in reverse so a lookup visits one node per label
+// of the hostname regardless of the number of suffixes.
+type Trie[T any] struct {
+	root *node[T]
+	size int
+}
+
+type Match[T any] struct {
+	Prefix   string
+	Suffix   string
+	Priority int
+	Value    T
+}
+
+type node[T any] struct {
+	children map[string]*node[T]
+	entry    *Match[T]
+}
+
+func New[T any]() *Trie[T] {
+	return &Trie[T]{root: newNode[T]()}
+}
+
+// Insert adds a suffix with a priority, lower values taking precedence. An
+// existing suffix is only replaced by one with a lower priority.
+func (t *Trie[T]) Insert(suffix string, priority int, v T) {
+	n := t.root
+
+	labels(suffix, func(label string) bool {
+		child, ok := n.children[label]
+		if !ok {
+			child = newNode[T]()
+			n.children[label] = child
+		}
+		n = child
+
+		return true
+	})
+
+	if n.entry != nil && n.entry.Priority <= priority {
+		return
+	}
+
+	if n.entry == nil {
+		t.size++
+	}
+
+	n.entry = &Match[T]{
+		Suffix:   suffix,
+		Priority: priority,
+		Value:    v,
+	}
+}
+
+// Match returns every suffix matching the host ordered by priority.
+func (t *Trie[T]) Match(host string) []Match[T] {
+	var ms []Match[T]
+
+	n := t.root
+	rest := host
+
+	labels(host, func(label string) bool {
+		child, ok := n.children[label]
+		if !ok {
+			return false
+		}
+		n = child
+
+		rest = strings.TrimSuffix(rest, label)
+		if n.entry != nil {
+			m := *n.entry
+			m.Prefix = strings.TrimSuffix(rest, ".")
+			ms = append(ms, m)
+		}
+		rest = strings.TrimSuffix(rest, ".")
+
+		return true
+	})
+
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Priority < ms[j].Priority
+	})
+
+	return ms
+}
+
+func (t *Trie[T]) Len() int {
+	return t.size
+}
+
+func newNode[T any]() *node[T] {
+	return &node[T]{children: make(map[string]*node[T])}
+}
+
+// labels calls fn with each label of the name from last to first until fn
+// returns false.
+func labels(name string, fn func(string) bool) {
+	for name != "" {
+		idx := strings.LastIndexByte(name, '.')
+		if !fn(name[idx+1:]) {
+			return
+		}
+		if idx < 0 {
+			return
+		}
+		name = name[:idx]
+	}
+}
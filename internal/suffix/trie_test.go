@@ -0,0 +1,57 @@
+package suffix
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTrieMatch(t *testing.T) {
+	trie := New[string]()
+	trie.Insert("example.org", 1, "example")
+	trie.Insert("b.example.org", 0, "b")
+	trie.Insert("org", 2, "org")
+	trie.Insert("example.org", 3, "ignored")
+
+	tests := map[string]struct {
+		host string
+		want []Match[string]
+	}{
+		"label boundary": {
+			host: "a.b.example.org",
+			want: []Match[string]{
+				{Prefix: "a", Suffix: "b.example.org", Priority: 0, Value: "b"},
+				{Prefix: "a.b", Suffix: "example.org", Priority: 1, Value: "example"},
+				{Prefix: "a.b.example", Suffix: "org", Priority: 2, Value: "org"},
+			},
+		},
+		"exact": {
+			host: "example.org",
+			want: []Match[string]{
+				{Prefix: "", Suffix: "example.org", Priority: 1, Value: "example"},
+				{Prefix: "example", Suffix: "org", Priority: 2, Value: "org"},
+			},
+		},
+		"partial label": {
+			host: "notexample.org",
+			want: []Match[string]{
+				{Prefix: "notexample", Suffix: "org", Priority: 2, Value: "org"},
+			},
+		},
+		"unmatched": {
+			host: "example.net",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, trie.Match(tt.host)); diff != "" {
+				t.Errorf("Match() mismatch (-want +got):\n%v", diff)
+			}
+		})
+	}
+
+	if got := trie.Len(); got != 3 {
+		t.Errorf("Len() = %v, want 3", got)
+	}
+}
@@ -0,0 +1,220 @@
+//go:build e2e
+
+// Package e2e runs muting against a local control plane started by envtest.
+//
+// The etcd and kube-apiserver binaries are located with KUBEBUILDER_ASSETS,
+// for example:
+//
+//	export KUBEBUILDER_ASSETS=$(setup-envtest use -p path)
+//	go test -tags e2e ./test/e2e/...
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikelorant/muting2/internal/app"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	namespace = "muting"
+	name      = "muting"
+	bind      = ":18443"
+	opsBind   = "127.0.0.1:18080"
+	timeout   = time.Minute
+)
+
+func TestE2E(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+
+	env := &envtest.Environment{}
+
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("unable to start control plane: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("unable to stop control plane: %v", err)
+		}
+	})
+
+	cl, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatalf("unable to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*timeout)
+	defer cancel()
+
+	createNamespace(ctx, t, cl, namespace, nil)
+	createNamespace(ctx, t, cl, "enabled", map[string]string{name: "enabled"})
+	createNamespace(ctx, t, cl, "disabled", nil)
+
+	_, err = cl.CoreV1().ConfigMaps(namespace).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Data: map[string]string{
+			"transforms": "- from: [example.org]\n  to: example.net\n",
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unable to create config map: %v", err)
+	}
+
+	actx, acancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Run(actx, app.Options{
+			Bind:      bind,
+			OpsBind:   opsBind,
+			Host:      "localhost",
+			Name:      name,
+			Namespace: namespace,
+			Service:   name,
+			Collision: app.CollisionPolicyWarn,
+			LogLevel:  "error",
+			LogFormat: "console",
+			Timeout:   5 * time.Second,
+			Config:    cfg,
+		})
+	}()
+	t.Cleanup(func() {
+		acancel()
+		if err := <-done; err != nil {
+			t.Errorf("app returned error: %v", err)
+		}
+	})
+
+	waitForReady(ctx, t)
+
+	t.Run("admission config", func(t *testing.T) {
+		mwc, err := cl.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unable to get admission config: %v", err)
+		}
+
+		if len(mwc.Webhooks) != 1 {
+			t.Fatalf("unexpected number of webhooks: %v", len(mwc.Webhooks))
+		}
+
+		if len(mwc.Webhooks[0].ClientConfig.CABundle) == 0 {
+			t.Errorf("CA bundle is empty")
+		}
+
+		if !bytes.Contains(mwc.Webhooks[0].ClientConfig.CABundle, []byte("BEGIN CERTIFICATE")) {
+			t.Errorf("CA bundle is not a PEM certificate")
+		}
+	})
+
+	t.Run("invalid transforms", func(t *testing.T) {
+		cm, err := cl.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unable to get config map: %v", err)
+		}
+
+		orig := cm.DeepCopy()
+		cm.Data["transforms"] = "- form: [example.org]\n  to: example.net\n"
+
+		_, verr := app.ValidateRules(cm.Data["transforms"], app.MatchingLabel)
+		if verr == nil {
+			t.Fatalf("ValidateRules() accepted invalid transforms")
+		}
+
+		updated, err := cl.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		if err != nil {
+			// The denial carries the validator message, unlike a failure to
+			// call the webhook or an unrelated API error.
+			if !strings.Contains(err.Error(), "denied the request") || !strings.Contains(err.Error(), verr.Error()) {
+				t.Errorf("Update() error = %v, want a webhook denial with %q", err, verr)
+			}
+			return
+		}
+		t.Errorf("invalid transforms were accepted")
+
+		orig.ResourceVersion = updated.ResourceVersion
+		if _, err := cl.CoreV1().ConfigMaps(namespace).Update(ctx, orig, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("unable to restore config map: %v", err)
+		}
+	})
+
+	t.Run("labelled namespace", func(t *testing.T) {
+		if got := createIngress(ctx, t, cl, "enabled"); got != "muting.example.net" {
+			t.Errorf("host = %v, want %v", got, "muting.example.net")
+		}
+	})
+
+	t.Run("unlabelled namespace", func(t *testing.T) {
+		if got := createIngress(ctx, t, cl, "disabled"); got != "muting.example.org" {
+			t.Errorf("host = %v, want %v", got, "muting.example.org")
+		}
+	})
+}
+
+func createNamespace(ctx context.Context, t *testing.T, cl kubernetes.Interface, name string, labels map[string]string) {
+	t.Helper()
+
+	_, err := cl.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unable to create namespace: %v: %v", name, err)
+	}
+}
+
+func createIngress(ctx context.Context, t *testing.T, cl kubernetes.Interface, namespace string) string {
+	t.Helper()
+
+	ing, err := cl.NetworkingV1().Ingresses(namespace).Create(ctx, &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "example"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{Host: "muting.example.org"}},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unable to create ingress: %v", err)
+	}
+
+	return ing.Spec.Rules[0].Host
+}
+
+func waitForReady(ctx context.Context, t *testing.T) {
+	t.Helper()
+
+	url := fmt.Sprintf("http://%v/readyz", opsBind)
+
+	err := wait.PollImmediateWithContext(ctx, time.Second, timeout, func(ctx context.Context) (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return false, err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode == http.StatusOK, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		t.Fatalf("timed out waiting for readiness")
+	}
+	if err != nil {
+		t.Fatalf("unable to wait for readiness: %v", err)
+	}
+}